@@ -0,0 +1,263 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+const (
+	binaryMagic      = "BAGS"
+	binaryVersion    = 1
+	binaryHeaderSize = 16
+	binaryTrailerSz  = 4
+	// binaryBatch is the number of fixed-width records read at once
+	binaryBatch = 4096
+)
+
+var (
+	// ErrFormat reports an encoded bag with an unexpected header
+	ErrFormat = errors.New("bad format")
+
+	// ErrChecksum reports an encoded bag whose checksum doesn't match its content
+	ErrChecksum = errors.New("checksum mismatch")
+
+	// ErrTooLong reports a string too long for the 32 bits length of its record
+	ErrTooLong = errors.New("too long")
+)
+
+// The binary encoding of a SortedRaw is made of
+//   - a 16-byte header: the "BAGS" magic, the version, the reflect.Kind of
+//     the items, the width of the items in bytes (0 for strings), a
+//     reserved byte and the number of items as a little-endian uint64;
+//   - the records: the items as little-endian fixed-width integers or IEEE
+//     754 floats, or the strings prefixed with their length as a
+//     little-endian uint32;
+//   - the CRC32 (IEEE) of the header and the records, as a little-endian uint32.
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface
+func (s SortedRaw[T]) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := s.WriteTo(&buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+// The items are sorted, as with DecodeSort.
+func (s *SortedRaw[T]) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := s.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrFormat, r.Len())
+	}
+	return nil
+}
+
+// WriteTo implements the io.WriterTo interface
+func (s SortedRaw[T]) WriteTo(w io.Writer) (int64, error) {
+	kind, width := rawLayout[T]()
+	cw := &checksumWriter{w: w, crc: crc32.NewIEEE()}
+	bw := bufio.NewWriter(cw)
+
+	header := make([]byte, 0, binaryHeaderSize)
+	header = append(header, binaryMagic...)
+	header = append(header, binaryVersion, byte(kind), byte(width), 0)
+	header = binary.LittleEndian.AppendUint64(header, uint64(len(s)))
+	bw.Write(header)
+
+	var record []byte
+	for _, x := range s {
+		var err error
+		if record, err = appendRaw(record[:0], x, width); err != nil {
+			return cw.n, err
+		}
+		if _, err = bw.Write(record); err != nil {
+			return cw.n, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	n, err := w.Write(binary.LittleEndian.AppendUint32(nil, cw.crc.Sum32()))
+	return cw.n + int64(n), err
+}
+
+// ReadFrom implements the io.ReaderFrom interface. It reads exactly one
+// encoded bag, validates its checksum and sorts the items, as with DecodeSort.
+// The array is left unchanged if an error is returned.
+func (s *SortedRaw[T]) ReadFrom(r io.Reader) (int64, error) {
+	return s.ReadFromMode(r, DecodeSort)
+}
+
+// ReadFromMode works like ReadFrom with the given decode mode
+func (s *SortedRaw[T]) ReadFromMode(r io.Reader, mode DecodeMode) (int64, error) {
+	kind, width := rawLayout[T]()
+	cr := &checksumReader{r: r, crc: crc32.NewIEEE()}
+
+	header := make([]byte, binaryHeaderSize)
+	if _, err := io.ReadFull(cr, header); err != nil {
+		return cr.n, err
+	}
+	if string(header[:4]) != binaryMagic || header[4] != binaryVersion {
+		return cr.n, fmt.Errorf("%w: magic or version", ErrFormat)
+	}
+	if reflect.Kind(header[5]) != kind || int(header[6]) != width {
+		return cr.n, fmt.Errorf("%w: encoded %v/%d, expected %v/%d",
+			ErrFormat, reflect.Kind(header[5]), header[6], kind, width)
+	}
+	count := binary.LittleEndian.Uint64(header[8:])
+
+	items := make([]T, 0, min(count, binaryBatch))
+	if width > 0 {
+		buf := make([]byte, width*binaryBatch)
+		for remaining := count; remaining > 0; {
+			batch := min(remaining, binaryBatch)
+			chunk := buf[:int(batch)*width]
+			if _, err := io.ReadFull(cr, chunk); err != nil {
+				return cr.n, unexpected(err)
+			}
+			for ; len(chunk) > 0; chunk = chunk[width:] {
+				items = append(items, decodeRaw[T](chunk, width))
+			}
+			remaining -= batch
+		}
+	} else {
+		var prefix [4]byte
+		var buf []byte
+		for i := uint64(0); i < count; i++ {
+			if _, err := io.ReadFull(cr, prefix[:]); err != nil {
+				return cr.n, unexpected(err)
+			}
+			size := binary.LittleEndian.Uint32(prefix[:])
+			// Grow progressively so that a corrupted size fails on the EOF
+			// rather than on a huge allocation.
+			buf = buf[:0]
+			for uint32(len(buf)) < size {
+				chunk := min(size-uint32(len(buf)), 1<<16)
+				buf = append(buf, make([]byte, chunk)...)
+				if _, err := io.ReadFull(cr, buf[len(buf)-int(chunk):]); err != nil {
+					return cr.n, unexpected(err)
+				}
+			}
+			items = append(items, decodeRawString[T](buf))
+		}
+	}
+
+	sum := cr.crc.Sum32()
+	trailer := make([]byte, binaryTrailerSz)
+	n, err := io.ReadFull(r, trailer)
+	if err != nil {
+		return cr.n + int64(n), unexpected(err)
+	}
+	if binary.LittleEndian.Uint32(trailer) != sum {
+		return cr.n + int64(n), ErrChecksum
+	}
+	if err = restoreOrder(items, mode, cmp.Compare[T]); err != nil {
+		return cr.n + int64(n), err
+	}
+	*s = items
+	return cr.n + int64(n), nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// rawLayout returns the kind of T and the width of its records, 0 for strings
+func rawLayout[T Ordered]() (reflect.Kind, int) {
+	var zero T
+	kind := reflect.TypeFor[T]().Kind()
+	if kind == reflect.String {
+		return kind, 0
+	}
+	return kind, int(unsafe.Sizeof(zero))
+}
+
+func appendRaw[T Ordered](buf []byte, x T, width int) ([]byte, error) {
+	p := unsafe.Pointer(&x)
+	switch width {
+	case 0:
+		str := *(*string)(p)
+		if uint64(len(str)) > math.MaxUint32 {
+			return buf, fmt.Errorf("%w: string of %d bytes", ErrTooLong, len(str))
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(str)))
+		return append(buf, str...), nil
+	case 1:
+		return append(buf, *(*uint8)(p)), nil
+	case 2:
+		return binary.LittleEndian.AppendUint16(buf, *(*uint16)(p)), nil
+	case 4:
+		return binary.LittleEndian.AppendUint32(buf, *(*uint32)(p)), nil
+	default:
+		return binary.LittleEndian.AppendUint64(buf, *(*uint64)(p)), nil
+	}
+}
+
+func decodeRaw[T Ordered](buf []byte, width int) (x T) {
+	p := unsafe.Pointer(&x)
+	switch width {
+	case 1:
+		*(*uint8)(p) = buf[0]
+	case 2:
+		*(*uint16)(p) = binary.LittleEndian.Uint16(buf)
+	case 4:
+		*(*uint32)(p) = binary.LittleEndian.Uint32(buf)
+	default:
+		*(*uint64)(p) = binary.LittleEndian.Uint64(buf)
+	}
+	return x
+}
+
+// decodeRawString returns a copy of the buffer as a T of the string kind
+func decodeRawString[T Ordered](buf []byte) (x T) {
+	*(*string)(unsafe.Pointer(&x)) = string(buf)
+	return x
+}
+
+// checksumWriter forwards the writes and accumulates their checksum
+type checksumWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	n   int64
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.crc.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+// checksumReader forwards the reads and accumulates their checksum
+type checksumReader struct {
+	r   io.Reader
+	crc hash.Hash32
+	n   int64
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
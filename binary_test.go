@@ -0,0 +1,119 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"testing"
+	"unsafe"
+)
+
+func TestBinary_RoundTrip(T *testing.T) {
+	ints := SortedRaw[int64]{math.MinInt64, -1, 0, 1, math.MaxInt64}
+	data, err := ints.MarshalBinary()
+	if err != nil {
+		T.Fatal(err)
+	}
+	if len(data) != binaryHeaderSize+8*len(ints)+binaryTrailerSz {
+		T.Fatal(len(data))
+	}
+	var ints2 SortedRaw[int64]
+	if err = ints2.UnmarshalBinary(data); err != nil {
+		T.Fatal(err)
+	}
+	for i := range ints {
+		if ints[i] != ints2[i] {
+			T.Fatal(i)
+		}
+	}
+
+	strs := SortedRaw[string]{"", "a", "hello world"}
+	var buf bytes.Buffer
+	n, err := strs.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		T.Fatal(n, err)
+	}
+	// A second bag in the same stream must not be consumed by the first read
+	if _, err = ints.WriteTo(&buf); err != nil {
+		T.Fatal(err)
+	}
+	var strs2 SortedRaw[string]
+	if _, err = strs2.ReadFrom(&buf); err != nil {
+		T.Fatal(err)
+	}
+	if strs2.Len() != 3 || strs2[2] != "hello world" {
+		T.Fatal(strs2)
+	}
+	if _, err = ints2.ReadFrom(&buf); err != nil || ints2.Len() != ints.Len() {
+		T.Fatal(err)
+	}
+
+	floats := SortedRaw[float32]{-1.5, 0, 2.25}
+	data, _ = floats.MarshalBinary()
+	var floats2 SortedRaw[float32]
+	if err = floats2.UnmarshalBinary(data); err != nil || floats2[0] != -1.5 || floats2[2] != 2.25 {
+		T.Fatal(err, floats2)
+	}
+}
+
+func TestBinary_Corruption(T *testing.T) {
+	bag := SortedRaw[string]{"alpha", "beta", "gamma"}
+	data, _ := bag.MarshalBinary()
+
+	var out SortedRaw[string]
+	for i := range data {
+		corrupted := bytes.Clone(data)
+		corrupted[i] ^= 0x40
+		if err := out.UnmarshalBinary(corrupted); err == nil {
+			T.Fatal("corruption undetected at", i)
+		}
+	}
+	if out != nil {
+		T.Fatal("bag altered by a failed decoding")
+	}
+	for i := 0; i < len(data); i++ {
+		if err := out.UnmarshalBinary(data[:i]); !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, ErrFormat) && err != io.EOF {
+			T.Fatal("truncation at", i, err)
+		}
+	}
+	if err := out.UnmarshalBinary(append(data, 0)); !errors.Is(err, ErrFormat) {
+		T.Fatal(err)
+	}
+	var ints SortedRaw[int64]
+	if err := ints.UnmarshalBinary(data); !errors.Is(err, ErrFormat) {
+		T.Fatal(err)
+	}
+}
+
+func TestBinary_Order(T *testing.T) {
+	unsorted := SortedRaw[uint16]{3, 1, 2}
+	data, _ := unsorted.MarshalBinary()
+	var bag SortedRaw[uint16]
+	if _, err := bag.ReadFromMode(bytes.NewReader(data), DecodeStrict); !errors.Is(err, ErrUnsorted) {
+		T.Fatal(err)
+	}
+	if err := bag.UnmarshalBinary(data); err != nil {
+		T.Fatal(err)
+	}
+	bag.Assert()
+}
+
+func TestBinary_TooLong(T *testing.T) {
+	if strconv.IntSize == 32 {
+		T.Skip("strings are shorter than 4GiB")
+	}
+	// The header claims a length past 4GiB, the bytes are never read
+	b := []byte{0}
+	huge := unsafe.String(&b[0], uint64(math.MaxUint32)+1)
+	bag := SortedRaw[string]{"a", huge}
+	if _, err := bag.WriteTo(io.Discard); !errors.Is(err, ErrTooLong) {
+		T.Fatal(err)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"hash/maphash"
+	"math"
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// DefaultBloomFalsePositiveRate is the false positive rate of a zero BloomRaw
+	DefaultBloomFalsePositiveRate = 0.01
+
+	// minBloomCapacity is the smallest number of items a filter is sized for
+	minBloomCapacity = 64
+)
+
+// BloomRaw implements a sorted array of raw types with a Bloom filter in
+// front of the lookups, to answer most of the lookups of absent items without
+// searching the array.
+// The filter is maintained by Add and Append, resized when the array outgrows
+// it, and rebuilt after each Remove.
+// The lookups are safe for concurrent use, as long as no mutation runs
+// concurrently.
+// The zero BloomRaw is empty, with a DefaultBloomFalsePositiveRate.
+type BloomRaw[T Ordered] struct {
+	items  SortedRaw[T]
+	filter bloomFilter
+	hash   func(x T) uint64
+	fpRate float64
+
+	lookups        atomic.Uint64
+	skipped        atomic.Uint64
+	falsePositives atomic.Uint64
+}
+
+// BloomStats reports how effective a Bloom filter is
+type BloomStats struct {
+	// Lookups counts the calls to Has, Get and GetIndex
+	Lookups uint64
+	// Skipped counts the lookups answered by the filter alone
+	Skipped uint64
+	// FalsePositives counts the lookups of absent items the filter didn't catch
+	FalsePositives uint64
+}
+
+type bloomFilter struct {
+	bits     []uint64
+	hashes   int
+	capacity int
+}
+
+// NewBloomRaw returns an empty array whose filter targets the given false positive rate
+func NewBloomRaw[T Ordered](fpRate float64) *BloomRaw[T] {
+	s := &BloomRaw[T]{fpRate: fpRate}
+	s.init()
+	return s
+}
+
+func (s *BloomRaw[T]) init() {
+	if s.hash != nil {
+		return
+	}
+	if s.fpRate <= 0 || s.fpRate >= 1 {
+		s.fpRate = DefaultBloomFalsePositiveRate
+	}
+	s.hash = bloomHasher[T](maphash.MakeSeed())
+	s.rebuild()
+}
+
+// Len returns the number of items in the array
+func (s *BloomRaw[T]) Len() int { return len(s.items) }
+
+// Items returns the underlying sorted array, that must not be modified
+func (s *BloomRaw[T]) Items() SortedRaw[T] { return s.items }
+
+// Stats returns the counters of the filter since its creation
+func (s *BloomRaw[T]) Stats() BloomStats {
+	return BloomStats{
+		Lookups:        s.lookups.Load(),
+		Skipped:        s.skipped.Load(),
+		FalsePositives: s.falsePositives.Load(),
+	}
+}
+
+// Add introduces a new item in the sorted array and in the filter
+func (s *BloomRaw[T]) Add(a T) {
+	s.init()
+	s.items.Add(a)
+	if len(s.items) > s.filter.capacity {
+		s.rebuild()
+	} else {
+		s.filter.add(s.hash(a))
+	}
+}
+
+// Append introduces several items in the sorted array and in the filter
+func (s *BloomRaw[T]) Append(a ...T) {
+	s.init()
+	s.items.Append(a...)
+	if len(s.items) > s.filter.capacity {
+		s.rebuild()
+	} else {
+		for _, x := range a {
+			s.filter.add(s.hash(x))
+		}
+	}
+}
+
+// Remove removes the first item with the given value and then rebuilds the filter
+func (s *BloomRaw[T]) Remove(a T) {
+	s.init()
+	if idx := s.items.GetIndex(a); idx >= 0 {
+		s.items.Remove(a)
+		s.rebuild()
+	}
+}
+
+func (s *BloomRaw[T]) rebuild() {
+	s.filter.reset(2*len(s.items), s.fpRate)
+	for _, x := range s.items {
+		s.filter.add(s.hash(x))
+	}
+}
+
+// GetIndex returns -1 if no item of the array is identical to the given value, or the position
+// of the first element.
+func (s *BloomRaw[T]) GetIndex(id T) int {
+	if s.hash == nil {
+		return s.items.GetIndex(id)
+	}
+	s.lookups.Add(1)
+	if !s.filter.mayContain(s.hash(id)) {
+		s.skipped.Add(1)
+		return -1
+	}
+	idx := s.items.GetIndex(id)
+	if idx < 0 {
+		s.falsePositives.Add(1)
+	}
+	return idx
+}
+
+// Get tests for the presence of the raw item in the current set and returns
+// a copy of the entity of it is present.
+func (s *BloomRaw[T]) Get(id T) (out T, ok bool) {
+	idx := s.GetIndex(id)
+	if idx >= 0 {
+		return s.items[idx], true
+	}
+	return out, false
+}
+
+// Has tests for the presence of the raw item in the current set
+func (s *BloomRaw[T]) Has(id T) bool { return s.GetIndex(id) >= 0 }
+
+// Slice returns at most max items strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (s *BloomRaw[T]) Slice(marker T, max uint32) []T { return s.items.Slice(marker, max) }
+
+// reset sizes the filter for the given number of items
+func (f *bloomFilter) reset(capacity int, fpRate float64) {
+	if capacity < minBloomCapacity {
+		capacity = minBloomCapacity
+	}
+	nbits := math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	f.capacity = capacity
+	f.bits = make([]uint64, (int(nbits)+63)/64)
+	f.hashes = int(math.Round(nbits / float64(capacity) * math.Ln2))
+	if f.hashes < 1 {
+		f.hashes = 1
+	}
+}
+
+func (f *bloomFilter) add(h uint64) {
+	m := uint64(len(f.bits) * 64)
+	h1, h2 := h, (h>>32)|1
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) mayContain(h uint64) bool {
+	m := uint64(len(f.bits) * 64)
+	h1, h2 := h, (h>>32)|1
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// bloomHasher returns a hash function consistent with the equality of T:
+// the strings are hashed by content and the numbers by value, with both
+// zeroes of the floating-point types hashing identically.
+func bloomHasher[T Ordered](seed maphash.Seed) func(x T) uint64 {
+	switch reflect.TypeFor[T]().Kind() {
+	case reflect.String:
+		return func(x T) uint64 {
+			return maphash.String(seed, *(*string)(unsafe.Pointer(&x)))
+		}
+	case reflect.Float32:
+		return func(x T) uint64 {
+			v := *(*float32)(unsafe.Pointer(&x))
+			if v == 0 {
+				v = 0
+			}
+			return maphash.Bytes(seed, unsafe.Slice((*byte)(unsafe.Pointer(&v)), unsafe.Sizeof(v)))
+		}
+	case reflect.Float64:
+		return func(x T) uint64 {
+			v := *(*float64)(unsafe.Pointer(&x))
+			if v == 0 {
+				v = 0
+			}
+			return maphash.Bytes(seed, unsafe.Slice((*byte)(unsafe.Pointer(&v)), unsafe.Sizeof(v)))
+		}
+	default:
+		return func(x T) uint64 {
+			return maphash.Bytes(seed, unsafe.Slice((*byte)(unsafe.Pointer(&x)), unsafe.Sizeof(x)))
+		}
+	}
+}
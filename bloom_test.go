@@ -0,0 +1,59 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestBloom_Lookup(T *testing.T) {
+	bag := NewBloomRaw[string](0.01)
+	for i := 0; i < 1000; i++ {
+		bag.Add("present-" + strconv.Itoa(i))
+	}
+	bag.Append("a", "b", "c")
+	for i := 0; i < 1000; i++ {
+		if !bag.Has("present-" + strconv.Itoa(i)) {
+			T.Fatal(i)
+		}
+	}
+	for i := 0; i < 10000; i++ {
+		if bag.Has("absent-" + strconv.Itoa(i)) {
+			T.Fatal(i)
+		}
+	}
+	stats := bag.Stats()
+	if stats.Lookups != 11000 {
+		T.Fatal("lookups", stats)
+	}
+	if stats.Skipped+stats.FalsePositives != 10000 || stats.FalsePositives > 500 {
+		T.Fatal("filter", stats)
+	}
+
+	bag.Remove("b")
+	if bag.Has("b") || !bag.Has("a") || !bag.Has("c") || bag.Len() != 1002 {
+		T.Fatal()
+	}
+	if x, ok := bag.Get("c"); !ok || x != "c" {
+		T.Fatal()
+	}
+	if idx := bag.GetIndex("a"); idx != 0 {
+		T.Fatal(idx)
+	}
+}
+
+func TestBloom_Float(T *testing.T) {
+	var bag BloomRaw[float64]
+	bag.Append(0, 1.5, math.Inf(1))
+	if !bag.Has(math.Copysign(0, -1)) || !bag.Has(1.5) || !bag.Has(math.Inf(1)) || bag.Has(2) {
+		T.Fatal()
+	}
+	if s := bag.Slice(0, 10); len(s) != 2 {
+		T.Fatal()
+	}
+}
@@ -0,0 +1,488 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+)
+
+const (
+	// MinBTreeFanout is the smallest fan-out accepted by BTreeObj
+	MinBTreeFanout = 4
+
+	// DefaultBTreeFanout is the fan-out used by a zero BTreeObj
+	DefaultBTreeFanout = 64
+)
+
+// BTreeObj implements an in-memory B+tree of objects providing a PRIMARY KEY.
+// It exposes the method set of SortedObj so that both backends are
+// interchangeable, but trades the compact storage of the sorted array for
+// O(log N) insertions and removals.
+// The items are only stored in the leaves, and the leaves are linked to
+// allow efficient range scans. Each internal node keeps the number of items
+// under each child so that positions (ranks) are computed in O(log N).
+// The zero BTreeObj is an empty tree with a DefaultBTreeFanout fan-out.
+type BTreeObj[PkType Ordered, T WithPK[PkType]] struct {
+	root   *bpNode[PkType, T]
+	fanout int
+	size   int
+}
+
+// objBag is the method set shared by SortedObj, BTreeObj and ColumnarObj, so that the call
+// sites may switch from a backend to the other.
+type objBag[PkType Ordered, T WithPK[PkType]] interface {
+	Len() int
+	Less(i, j int) bool
+	Swap(i, j int)
+	Add(a T)
+	Append(a ...T)
+	Slice(marker PkType, max uint32) []T
+	GetIndex(id PkType) int
+	Get(id PkType) (T, bool)
+	Has(id PkType) bool
+	Remove(pk PkType)
+	SearchItem(predicate func(x *T) bool) int
+	SearchIndex(predicate func(i int) bool) int
+	SearchPK(needle PkType) int
+	Update(pk PkType, mutate func(x *T)) bool
+	UpdateChanged(pk PkType, mutate func(x *T)) (found, changed bool)
+}
+
+var (
+	_ objBag[int64, WithPK[int64]] = (*SortedObj[int64, WithPK[int64]])(nil)
+	_ objBag[int64, WithPK[int64]] = (*BTreeObj[int64, WithPK[int64]])(nil)
+	_ objBag[int64, WithPK[int64]] = (*ColumnarObj[int64, WithPK[int64]])(nil)
+)
+
+// bpNode is either a leaf (children is nil) or an internal node.
+type bpNode[PkType Ordered, T WithPK[PkType]] struct {
+	// items holds the elements of a leaf
+	items []T
+	// next points to the right sibling of a leaf
+	next *bpNode[PkType, T]
+
+	// children holds the sub-trees of an internal node
+	children []*bpNode[PkType, T]
+	// maxKeys holds the greatest PRIMARY KEY of each child
+	maxKeys []PkType
+	// counts holds the number of items under each child
+	counts []int
+}
+
+// NewBTreeObj returns an empty tree whose nodes hold at most fanout entries.
+// The fan-out is raised to MinBTreeFanout if necessary.
+func NewBTreeObj[PkType Ordered, T WithPK[PkType]](fanout int) *BTreeObj[PkType, T] {
+	if fanout < MinBTreeFanout {
+		fanout = MinBTreeFanout
+	}
+	return &BTreeObj[PkType, T]{fanout: fanout}
+}
+
+// Len returns the number of items in the tree
+func (t *BTreeObj[PkType, T]) Len() int { return t.size }
+
+// Swap implements a method of the sort.Interface. As for SortedObj, the lookups
+// are only valid once the items are sorted again.
+func (t *BTreeObj[PkType, T]) Swap(i, j int) {
+	li, pi := t.locate(i)
+	lj, pj := t.locate(j)
+	li.items[pi], lj.items[pj] = lj.items[pj], li.items[pi]
+}
+
+// Less implements a method of the sort.Interface
+func (t *BTreeObj[PkType, T]) Less(i, j int) bool { return t.At(i).PK() < t.At(j).PK() }
+
+func (t *BTreeObj[PkType, T]) maxFill() int {
+	if t.fanout <= 0 {
+		t.fanout = DefaultBTreeFanout
+	}
+	return t.fanout
+}
+
+func (t *BTreeObj[PkType, T]) minFill() int { return t.maxFill() / 2 }
+
+// Add introduces a new item in the tree, regardless the presence of another item with the same PRIMARY KEY.
+// The new item is placed after the items with the same PRIMARY KEY.
+func (t *BTreeObj[PkType, T]) Add(a T) {
+	if t.root == nil {
+		t.root = &bpNode[PkType, T]{}
+	}
+	if sibling := t.insert(t.root, a); sibling != nil {
+		left := t.root
+		t.root = &bpNode[PkType, T]{
+			children: []*bpNode[PkType, T]{left, sibling},
+			maxKeys:  []PkType{left.maxKey(), sibling.maxKey()},
+			counts:   []int{left.count(), sibling.count()},
+		}
+	}
+	t.size++
+}
+
+// Append introduces several items in the tree, regardless the presence of other items with the same PRIMARY KEY
+func (t *BTreeObj[PkType, T]) Append(a ...T) {
+	for _, x := range a {
+		t.Add(x)
+	}
+}
+
+// Slice returns at most max items whose PRIMARY KEY is strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (t *BTreeObj[PkType, T]) Slice(marker PkType, max uint32) []T {
+	max = boundSliceSize(max)
+	out := make([]T, 0)
+	leaf, pos, _ := t.seek(marker, true)
+	for ; leaf != nil && uint32(len(out)) < max; leaf, pos = leaf.next, 0 {
+		remaining := int(max) - len(out)
+		end := len(leaf.items)
+		if end-pos > remaining {
+			end = pos + remaining
+		}
+		out = append(out, leaf.items[pos:end]...)
+	}
+	return out
+}
+
+// GetIndex returns -1 if no item of the tree has the given PRIMARY KEY, or the rank of the first
+// item with that PRIMARY KEY
+func (t *BTreeObj[PkType, T]) GetIndex(id PkType) int {
+	leaf, pos, rank := t.seek(id, false)
+	if leaf != nil && leaf.items[pos].PK() == id {
+		return rank
+	}
+	return -1
+}
+
+// Get returns the first item with the given PRIMARY KEY
+func (t *BTreeObj[PkType, T]) Get(id PkType) (out T, ok bool) {
+	leaf, pos, _ := t.seek(id, false)
+	if leaf != nil && leaf.items[pos].PK() == id {
+		return leaf.items[pos], true
+	}
+	return out, false
+}
+
+// Has tests for the presence of an item in the tree, given the primary key of the item
+func (t *BTreeObj[PkType, T]) Has(id PkType) bool { return t.GetIndex(id) >= 0 }
+
+// At returns the item at the given rank in the tree, or panics if the rank is out of range
+func (t *BTreeObj[PkType, T]) At(rank int) T {
+	leaf, pos := t.locate(rank)
+	return leaf.items[pos]
+}
+
+// locate returns the leaf holding the item at the given rank, and the position
+// of the item in the leaf. It panics if the rank is out of range.
+func (t *BTreeObj[PkType, T]) locate(rank int) (*bpNode[PkType, T], int) {
+	if rank < 0 || rank >= t.size {
+		panic("index out of range")
+	}
+	n := t.root
+	for !n.isLeaf() {
+		i := 0
+		for ; rank >= n.counts[i]; i++ {
+			rank -= n.counts[i]
+		}
+		n = n.children[i]
+	}
+	return n, rank
+}
+
+// SearchItem returns the rank of the first item matching the monotonic predicate, or -1 if there is none
+func (t *BTreeObj[PkType, T]) SearchItem(predicate func(x *T) bool) int {
+	return t.SearchIndex(func(i int) bool {
+		leaf, pos := t.locate(i)
+		return predicate(&leaf.items[pos])
+	})
+}
+
+// SearchIndex returns the first rank matching the monotonic predicate, or -1 if there is none
+func (t *BTreeObj[PkType, T]) SearchIndex(predicate func(i int) bool) int {
+	i := sort.Search(t.size, predicate)
+	if i < t.size {
+		return i
+	}
+	return -1
+}
+
+// SearchPK returns the rank of the first item whose PRIMARY KEY is greater or equal to the
+// needle, or -1 if there is none.
+func (t *BTreeObj[PkType, T]) SearchPK(needle PkType) int {
+	leaf, _, rank := t.seek(needle, false)
+	if leaf != nil {
+		return rank
+	}
+	return -1
+}
+
+// Remove identifies the first item with the given PRIMARY KEY and then removes it from the tree.
+func (t *BTreeObj[PkType, T]) Remove(pk PkType) {
+	if rank := t.GetIndex(pk); rank >= 0 {
+		t.removeRank(rank)
+	}
+}
+
+// Update applies the mutation to the first item with the given PRIMARY KEY, then moves the
+// item after the items with its new PRIMARY KEY if the PRIMARY KEY changed. It returns false
+// if no item has the given PRIMARY KEY.
+func (t *BTreeObj[PkType, T]) Update(pk PkType, mutate func(x *T)) bool {
+	found, _ := t.UpdateChanged(pk, mutate)
+	return found
+}
+
+// UpdateChanged works like Update and also reports if the PRIMARY KEY of the item changed
+func (t *BTreeObj[PkType, T]) UpdateChanged(pk PkType, mutate func(x *T)) (found, changed bool) {
+	leaf, pos, rank := t.seek(pk, false)
+	if leaf == nil || leaf.items[pos].PK() != pk {
+		return false, false
+	}
+	mutate(&leaf.items[pos])
+	x := leaf.items[pos]
+	if x.PK() == pk {
+		return true, false
+	}
+	t.removeRank(rank)
+	t.Add(x)
+	return true, true
+}
+
+func (t *BTreeObj[PkType, T]) removeRank(rank int) {
+	t.remove(t.root, rank)
+	t.size--
+	if !t.root.isLeaf() && len(t.root.children) == 1 {
+		t.root = t.root.children[0]
+	}
+}
+
+// Each calls the hook on every item, in order, until the hook returns false
+func (t *BTreeObj[PkType, T]) Each(hook func(x T) bool) {
+	for leaf := t.firstLeaf(); leaf != nil; leaf = leaf.next {
+		for _, x := range leaf.items {
+			if !hook(x) {
+				return
+			}
+		}
+	}
+}
+
+func (t *BTreeObj[PkType, T]) firstLeaf() *bpNode[PkType, T] {
+	n := t.root
+	for n != nil && !n.isLeaf() {
+		n = n.children[0]
+	}
+	return n
+}
+
+// seek returns the position of the first item whose PRIMARY KEY is greater or
+// equal to the target, or strictly greater if after is set, with its rank in
+// the whole tree. The leaf is nil if no item matches.
+func (t *BTreeObj[PkType, T]) seek(target PkType, after bool) (*bpNode[PkType, T], int, int) {
+	if t.size == 0 {
+		return nil, 0, t.size
+	}
+	keyCompare, itemCompare := cmp.Compare[PkType], objComparePK[PkType, T]
+	if after {
+		keyCompare, itemCompare = rawUpperBound[PkType], objUpperBound[PkType, T]
+	}
+	n, rank := t.root, 0
+	for !n.isLeaf() {
+		i, _ := slices.BinarySearchFunc(n.maxKeys, target, keyCompare)
+		if i >= len(n.children) {
+			return nil, 0, t.size
+		}
+		for _, c := range n.counts[:i] {
+			rank += c
+		}
+		n = n.children[i]
+	}
+	pos, _ := slices.BinarySearchFunc(n.items, target, itemCompare)
+	if pos >= len(n.items) {
+		return nil, 0, t.size
+	}
+	return n, pos, rank + pos
+}
+
+// insert places the item in the sub-tree rooted at n and returns the new
+// right sibling of n if n had to be split.
+func (t *BTreeObj[PkType, T]) insert(n *bpNode[PkType, T], a T) *bpNode[PkType, T] {
+	pk := a.PK()
+	if n.isLeaf() {
+		pos, _ := slices.BinarySearchFunc(n.items, pk, objUpperBound[PkType, T])
+		n.items = slices.Insert(n.items, pos, a)
+		if len(n.items) <= t.maxFill() {
+			return nil
+		}
+		half := len(n.items) / 2
+		sibling := &bpNode[PkType, T]{items: append([]T(nil), n.items[half:]...), next: n.next}
+		clear(n.items[half:])
+		n.items = n.items[:half]
+		n.next = sibling
+		return sibling
+	}
+
+	i, _ := slices.BinarySearchFunc(n.maxKeys, pk, rawUpperBound[PkType])
+	if i >= len(n.children) {
+		i = len(n.children) - 1
+	}
+	child := n.children[i]
+	sibling := t.insert(child, a)
+	n.maxKeys[i] = child.maxKey()
+	n.counts[i] = child.count()
+	if sibling != nil {
+		n.children = insertAt(n.children, i+1, sibling)
+		n.maxKeys = insertAt(n.maxKeys, i+1, sibling.maxKey())
+		n.counts = insertAt(n.counts, i+1, sibling.count())
+	}
+	if len(n.children) <= t.maxFill() {
+		return nil
+	}
+	half := len(n.children) / 2
+	out := &bpNode[PkType, T]{
+		children: append([]*bpNode[PkType, T](nil), n.children[half:]...),
+		maxKeys:  append([]PkType(nil), n.maxKeys[half:]...),
+		counts:   append([]int(nil), n.counts[half:]...),
+	}
+	clear(n.children[half:])
+	n.children = n.children[:half]
+	n.maxKeys = n.maxKeys[:half]
+	n.counts = n.counts[:half]
+	return out
+}
+
+// remove deletes the item at the given rank in the sub-tree rooted at n, and
+// then restores the fill factor of the children of n. The descent only relies
+// on the counts, so the item may have been altered.
+func (t *BTreeObj[PkType, T]) remove(n *bpNode[PkType, T], rank int) {
+	if n.isLeaf() {
+		n.items = removeAt(n.items, rank)
+		return
+	}
+
+	i := 0
+	for ; rank >= n.counts[i]; i++ {
+		rank -= n.counts[i]
+	}
+	t.remove(n.children[i], rank)
+	n.counts[i]--
+	if n.counts[i] > 0 {
+		n.maxKeys[i] = n.children[i].maxKey()
+	}
+	if n.children[i].fill() < t.minFill() && len(n.children) > 1 {
+		t.rebalance(n, i)
+	}
+}
+
+// rebalance restores the fill factor of the i-th child of n, either by
+// moving one entry from a sibling or by merging the child with a sibling.
+func (t *BTreeObj[PkType, T]) rebalance(n *bpNode[PkType, T], i int) {
+	if i > 0 && n.children[i-1].fill() > t.minFill() {
+		left, child := n.children[i-1], n.children[i]
+		if child.isLeaf() {
+			last := len(left.items) - 1
+			child.items = insertAt(child.items, 0, left.items[last])
+			left.items = removeAt(left.items, last)
+		} else {
+			last := len(left.children) - 1
+			child.children = insertAt(child.children, 0, left.children[last])
+			child.maxKeys = insertAt(child.maxKeys, 0, left.maxKeys[last])
+			child.counts = insertAt(child.counts, 0, left.counts[last])
+			left.children = removeAt(left.children, last)
+			left.maxKeys = removeAt(left.maxKeys, last)
+			left.counts = removeAt(left.counts, last)
+		}
+		n.refresh(i - 1)
+		n.refresh(i)
+		return
+	}
+	if i+1 < len(n.children) && n.children[i+1].fill() > t.minFill() {
+		child, right := n.children[i], n.children[i+1]
+		if child.isLeaf() {
+			child.items = append(child.items, right.items[0])
+			right.items = removeAt(right.items, 0)
+		} else {
+			child.children = append(child.children, right.children[0])
+			child.maxKeys = append(child.maxKeys, right.maxKeys[0])
+			child.counts = append(child.counts, right.counts[0])
+			right.children = removeAt(right.children, 0)
+			right.maxKeys = removeAt(right.maxKeys, 0)
+			right.counts = removeAt(right.counts, 0)
+		}
+		n.refresh(i)
+		n.refresh(i + 1)
+		return
+	}
+
+	// No sibling can spare an entry, merge the child with its right sibling,
+	// or with its left sibling for the last child.
+	if i+1 >= len(n.children) {
+		i--
+	}
+	left, right := n.children[i], n.children[i+1]
+	if left.isLeaf() {
+		left.items = append(left.items, right.items...)
+		left.next = right.next
+	} else {
+		left.children = append(left.children, right.children...)
+		left.maxKeys = append(left.maxKeys, right.maxKeys...)
+		left.counts = append(left.counts, right.counts...)
+	}
+	n.children = removeAt(n.children, i+1)
+	n.maxKeys = removeAt(n.maxKeys, i+1)
+	n.counts = removeAt(n.counts, i+1)
+	n.refresh(i)
+}
+
+func (n *bpNode[PkType, T]) isLeaf() bool { return n.children == nil }
+
+// fill returns the number of entries in the node
+func (n *bpNode[PkType, T]) fill() int {
+	if n.isLeaf() {
+		return len(n.items)
+	}
+	return len(n.children)
+}
+
+// count returns the number of items under the node
+func (n *bpNode[PkType, T]) count() int {
+	if n.isLeaf() {
+		return len(n.items)
+	}
+	total := 0
+	for _, c := range n.counts {
+		total += c
+	}
+	return total
+}
+
+// maxKey returns the greatest PRIMARY KEY under a non-empty node
+func (n *bpNode[PkType, T]) maxKey() PkType {
+	if n.isLeaf() {
+		return n.items[len(n.items)-1].PK()
+	}
+	return n.maxKeys[len(n.maxKeys)-1]
+}
+
+// refresh recomputes the summary of the i-th child
+func (n *bpNode[PkType, T]) refresh(i int) {
+	n.maxKeys[i] = n.children[i].maxKey()
+	n.counts[i] = n.children[i].count()
+}
+
+func insertAt[T any](s []T, i int, x T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = x
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	var zero T
+	copy(s[i:], s[i+1:])
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBTree_Lookup(T *testing.T) {
+	bag := NewBTreeObj[int64, *Obj](4)
+	bag.Append(&Obj{3}, &Obj{1}, &Obj{0}, &Obj{2})
+	bag.Assert()
+	for idx, v := range []int64{0, 1, 2, 3} {
+		if !bag.Has(v) {
+			T.Fatal()
+		}
+		if x, ok := bag.Get(v); !ok {
+			T.Fatal()
+		} else if x.PK() != v {
+			T.Fatal()
+		}
+		if idx != bag.GetIndex(v) {
+			T.Fatal()
+		}
+		if bag.At(idx).PK() != v {
+			T.Fatal()
+		}
+	}
+	for _, v := range []int64{-1, -2, 5, 6} {
+		if bag.Has(v) {
+			T.Fatal()
+		}
+		if _, ok := bag.Get(v); ok {
+			T.Fatal()
+		}
+		if -1 != bag.GetIndex(v) {
+			T.Fatal()
+		}
+	}
+}
+
+func TestBTree_Slice(T *testing.T) {
+	bag := NewBTreeObj[int64, *Obj](4)
+	for i := int64(0); i < 3*MaxSliceSize; i++ {
+		bag.Add(&Obj{i})
+	}
+	bag.Assert()
+	testSlice := func(marker int64, max uint32, first int64, count int) {
+		slice := bag.Slice(marker, max)
+		if len(slice) != count {
+			T.Fatal("marker", marker, "max", max, "len", len(slice))
+		}
+		for i, v := range slice {
+			if v.PK() != first+int64(i) {
+				T.Fatal()
+			}
+		}
+	}
+	testSlice(0, 2, 1, 2)
+	testSlice(-1, 2, 0, 2)
+	testSlice(3*MaxSliceSize-1, 1, 0, 0)
+	testSlice(-1, MinSliceSize-1, 0, MinSliceSize)
+	testSlice(-1, MaxSliceSize+1, 0, MaxSliceSize)
+	testSlice(2*MaxSliceSize+10, MaxSliceSize, 2*MaxSliceSize+11, MaxSliceSize-11)
+}
+
+func TestBTree_Duplicates(T *testing.T) {
+	bag := NewBTreeObj[int64, *Obj](4)
+	for i := 0; i < 20; i++ {
+		bag.Add(&Obj{int64(i % 2)})
+	}
+	bag.Assert()
+	if bag.GetIndex(0) != 0 || bag.GetIndex(1) != 10 {
+		T.Fatal()
+	}
+	for i := 0; i < 10; i++ {
+		bag.Remove(0)
+		bag.Assert()
+	}
+	if bag.Has(0) || bag.Len() != 10 || bag.GetIndex(1) != 0 {
+		T.Fatal()
+	}
+}
+
+func TestBTree_Random(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	for _, fanout := range []int{0, 4, 5, 16} {
+		bag := NewBTreeObj[int64, *Obj](fanout)
+		var ref SortedObj[int64, *Obj]
+		for i := 0; i < 5000; i++ {
+			v := rng.Int63n(500)
+			if rng.Intn(3) == 0 {
+				bag.Remove(v)
+				ref.Remove(v)
+			} else if !ref.Has(v) {
+				bag.Add(&Obj{v})
+				ref.Add(&Obj{v})
+			}
+			if bag.Len() != ref.Len() {
+				T.Fatal("len", bag.Len(), ref.Len())
+			}
+			if bag.GetIndex(v) != ref.GetIndex(v) {
+				T.Fatal("index", v)
+			}
+		}
+		bag.Assert()
+		for i, x := range ref {
+			if bag.At(i).PK() != x.PK() {
+				T.Fatal("rank", i)
+			}
+		}
+		for len(ref) > 0 {
+			v := ref[rng.Intn(len(ref))].PK()
+			bag.Remove(v)
+			ref.Remove(v)
+			bag.Assert()
+		}
+		if bag.Len() != 0 {
+			T.Fatal()
+		}
+	}
+}
+
+func TestBTree_Search(T *testing.T) {
+	bag := NewBTreeObj[int64, *Obj](4)
+	var ref SortedObj[int64, *Obj]
+	for i := int64(0); i < 100; i++ {
+		bag.Add(&Obj{i / 3})
+		ref.Add(&Obj{i / 3})
+	}
+	for v := int64(-1); v < 40; v++ {
+		if bag.SearchPK(v) != ref.SearchPK(v) {
+			T.Fatal("pk", v)
+		}
+		if bag.SearchItem(func(x **Obj) bool { return (*x).pk > v }) != ref.SearchItem(func(x **Obj) bool { return (*x).pk > v }) {
+			T.Fatal("item", v)
+		}
+	}
+	if bag.SearchIndex(func(i int) bool { return i >= 7 }) != 7 || bag.SearchIndex(func(i int) bool { return false }) != -1 {
+		T.Fatal()
+	}
+
+	// The items may be sorted again through the sort.Interface
+	for i := 0; i < bag.Len()/2; i++ {
+		bag.Swap(i, bag.Len()-1-i)
+	}
+	if bag.Less(0, 1) || !bag.Less(bag.Len()-1, 0) {
+		T.Fatal()
+	}
+	sort.Stable(bag)
+	bag.Assert()
+}
+
+func TestBTree_Update(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	bag := NewBTreeObj[int64, Cmp2Int](4)
+	var ref SortedObj[int64, Cmp2Int]
+	for i := 0; i < 300; i++ {
+		x := Cmp2Int{rng.Intn(50), i}
+		bag.Add(x)
+		ref.Add(x)
+	}
+	for i := 0; i < 2000; i++ {
+		pk, a := int64(rng.Intn(60)), rng.Intn(50)
+		mutate := func(x *Cmp2Int) { x.A, x.B = a, -i }
+		found, changed := bag.UpdateChanged(pk, mutate)
+		f, c := ref.UpdateChanged(pk, mutate)
+		if found != f || changed != c {
+			T.Fatal(i, found, changed)
+		}
+	}
+	bag.Assert()
+	i := 0
+	bag.Each(func(x Cmp2Int) bool {
+		if x != ref[i] {
+			T.Fatal("diverged", i)
+		}
+		i++
+		return true
+	})
+}
+
+// Assert panics if Check returns an error
+func (t *BTreeObj[PkType, T]) Assert() {
+	if err := t.Check(); err != nil {
+		panic(err)
+	}
+}
+
+// Check validates the ordering of the items, the summaries of the internal
+// nodes, the fill factor of the nodes and the chaining of the leaves.
+func (t *BTreeObj[PkType, T]) Check() error {
+	if t.root == nil {
+		if t.size != 0 {
+			return errors.New("bad size")
+		}
+		return nil
+	}
+	var leaves []*bpNode[PkType, T]
+	var check func(n *bpNode[PkType, T], depth int) (int, error)
+	leafDepth := -1
+	check = func(n *bpNode[PkType, T], depth int) (int, error) {
+		if n != t.root && n.fill() < t.minFill() {
+			return 0, errors.New("underflow")
+		}
+		if n.fill() > t.maxFill() {
+			return 0, errors.New("overflow")
+		}
+		if n.isLeaf() {
+			if leafDepth < 0 {
+				leafDepth = depth
+			} else if leafDepth != depth {
+				return 0, errors.New("unbalanced")
+			}
+			leaves = append(leaves, n)
+			return len(n.items), nil
+		}
+		total := 0
+		for i, c := range n.children {
+			count, err := check(c, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			if count != n.counts[i] {
+				return 0, errors.New("bad count")
+			}
+			if c.maxKey() != n.maxKeys[i] {
+				return 0, errors.New("bad max key")
+			}
+			total += count
+		}
+		return total, nil
+	}
+	total, err := check(t.root, 0)
+	if err != nil {
+		return err
+	}
+	if total != t.size {
+		return errors.New("bad size")
+	}
+	for i, leaf := range leaves {
+		if i+1 < len(leaves) && leaf.next != leaves[i+1] {
+			return errors.New("bad chaining")
+		}
+	}
+	var last *T
+	var lastErr error
+	t.Each(func(x T) bool {
+		if last != nil && (*last).PK() > x.PK() {
+			lastErr = ErrUnsorted
+			return false
+		}
+		last = &x
+		return true
+	})
+	return lastErr
+}
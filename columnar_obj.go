@@ -0,0 +1,164 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"slices"
+	"sort"
+)
+
+// ColumnarObj implements a sorted array of objects providing a PRIMARY KEY,
+// with the same method set as SortedObj.
+// The PRIMARY KEYS are cached in a contiguous column parallel to the items,
+// so that the searches only scan the key column and never call PK() nor
+// dereference the items. That layout pays off when T is a pointer type.
+// ColumnarObj costs the extra memory of the key column, and requires the
+// PRIMARY KEY of an item to remain constant once inserted, except through Update.
+// The zero ColumnarObj is empty and ready to use.
+type ColumnarObj[PkType Ordered, T WithPK[PkType]] struct {
+	keys  []PkType
+	items []T
+}
+
+// Len implements a method of the sort.Interface
+func (s *ColumnarObj[PkType, T]) Len() int { return len(s.keys) }
+
+// Swap implements a method of the sort.Interface
+func (s *ColumnarObj[PkType, T]) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+}
+
+// Less implements a method of the sort.Interface
+func (s *ColumnarObj[PkType, T]) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+
+// Items returns the sorted items, that must not be modified
+func (s *ColumnarObj[PkType, T]) Items() []T { return s.items }
+
+// Keys returns the sorted PRIMARY KEYS, that must not be modified
+func (s *ColumnarObj[PkType, T]) Keys() []PkType { return s.keys }
+
+// Add introduces a new item in the sorted array, regardless the presence of another item with the same PRIMARY KEY
+// and preserves the ordering of the array.
+func (s *ColumnarObj[PkType, T]) Add(a T) {
+	pk := a.PK()
+	i, _ := slices.BinarySearchFunc(s.keys, pk, rawUpperBound[PkType])
+	s.keys = slices.Insert(s.keys, i, pk)
+	s.items = slices.Insert(s.items, i, a)
+}
+
+// Append introduces several items in the sorted array, regardless the presence of other items with the same PRIMARY KEY
+// and preserves the ordering of the array. The batch is sorted and then merged in a single pass,
+// after the items with the same PRIMARY KEY.
+func (s *ColumnarObj[PkType, T]) Append(a ...T) {
+	if len(a) == 0 {
+		return
+	}
+	batch := slices.Clone(a)
+	slices.SortStableFunc(batch, objCompare[PkType, T])
+	keys := make([]PkType, 0, len(s.keys)+len(batch))
+	items := make([]T, 0, len(s.items)+len(batch))
+	i := 0
+	for _, x := range batch {
+		pk := x.PK()
+		j, _ := slices.BinarySearchFunc(s.keys[i:], pk, rawUpperBound[PkType])
+		keys = append(keys, s.keys[i:i+j]...)
+		items = append(items, s.items[i:i+j]...)
+		keys = append(keys, pk)
+		items = append(items, x)
+		i += j
+	}
+	s.keys = append(keys, s.keys[i:]...)
+	s.items = append(items, s.items[i:]...)
+}
+
+func (s *ColumnarObj[PkType, T]) Slice(marker PkType, max uint32) []T {
+	max = boundSliceSize(max)
+	start, _ := slices.BinarySearchFunc(s.keys, marker, rawUpperBound[PkType])
+	if start >= len(s.keys) {
+		return s.items[:0]
+	}
+	remaining := uint32(len(s.keys) - start)
+	if remaining > max {
+		remaining = max
+	}
+	return s.items[start : uint32(start)+remaining]
+}
+
+// GetIndex returns -1 if no item of the array has the given PRIMARY KEY, or the position of the first
+// element with that PRIMARY KEY
+func (s *ColumnarObj[PkType, T]) GetIndex(id PkType) int {
+	if i, found := slices.BinarySearch(s.keys, id); found {
+		return i
+	}
+	return -1
+}
+
+func (s *ColumnarObj[PkType, T]) Get(id PkType) (out T, ok bool) {
+	idx := s.GetIndex(id)
+	if idx >= 0 {
+		return s.items[idx], true
+	}
+	return out, false
+}
+
+// Has tests for the presence of an item in the set, given the private key of the item
+func (s *ColumnarObj[PkType, T]) Has(id PkType) bool { return s.GetIndex(id) >= 0 }
+
+// Remove identifies the position of the element with the given PRIMARY KEY
+// and then removes it from both columns, preserving the ordering.
+func (s *ColumnarObj[PkType, T]) Remove(pk PkType) {
+	if idx := s.GetIndex(pk); idx >= 0 {
+		s.keys = slices.Delete(s.keys, idx, idx+1)
+		s.items = slices.Delete(s.items, idx, idx+1)
+	}
+}
+
+// Update applies the mutation to the first item with the given PRIMARY KEY, then moves the
+// item after the items with its new PRIMARY KEY if the PRIMARY KEY changed, preserving the
+// ordering of the set. It returns false if no item has the given PRIMARY KEY.
+func (s *ColumnarObj[PkType, T]) Update(pk PkType, mutate func(x *T)) bool {
+	found, _ := s.UpdateChanged(pk, mutate)
+	return found
+}
+
+// UpdateChanged works like Update and also reports if the PRIMARY KEY of the item changed
+func (s *ColumnarObj[PkType, T]) UpdateChanged(pk PkType, mutate func(x *T)) (found, changed bool) {
+	idx := s.GetIndex(pk)
+	if idx < 0 {
+		return false, false
+	}
+	mutate(&s.items[idx])
+	x := s.items[idx]
+	if x.PK() == pk {
+		return true, false
+	}
+	s.keys = slices.Delete(s.keys, idx, idx+1)
+	s.items = slices.Delete(s.items, idx, idx+1)
+	s.Add(x)
+	return true, true
+}
+
+func (s *ColumnarObj[PkType, T]) SearchItem(predicate func(x *T) bool) int {
+	return s.SearchIndex(func(i int) bool {
+		return predicate(&s.items[i])
+	})
+}
+
+func (s *ColumnarObj[PkType, T]) SearchIndex(predicate func(i int) bool) int {
+	i := sort.Search(len(s.keys), predicate)
+	if i < len(s.keys) {
+		return i
+	}
+	return -1
+}
+
+func (s *ColumnarObj[PkType, T]) SearchPK(needle PkType) int {
+	if i, _ := slices.BinarySearch(s.keys, needle); i < len(s.keys) {
+		return i
+	}
+	return -1
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestColumnar_Lookup(T *testing.T) {
+	var bag ColumnarObj[int64, *Obj]
+	bag.Append(&Obj{3}, &Obj{1}, &Obj{0}, &Obj{2})
+	bag.Assert()
+	for idx, v := range []int64{0, 1, 2, 3} {
+		if !bag.Has(v) {
+			T.Fatal()
+		}
+		if x, ok := bag.Get(v); !ok || x.PK() != v {
+			T.Fatal()
+		}
+		if idx != bag.GetIndex(v) || idx != bag.SearchPK(v) {
+			T.Fatal()
+		}
+	}
+	for _, v := range []int64{-1, -2, 5, 6} {
+		if bag.Has(v) {
+			T.Fatal()
+		}
+		if _, ok := bag.Get(v); ok {
+			T.Fatal()
+		}
+		if -1 != bag.GetIndex(v) {
+			T.Fatal()
+		}
+	}
+	if idx := bag.SearchItem(func(x **Obj) bool { return (*x).pk >= 2 }); idx != 2 {
+		T.Fatal()
+	}
+}
+
+func TestColumnar_AddRemove(T *testing.T) {
+	var bag ColumnarObj[int64, *Obj]
+	for _, v := range []int64{3, 1, 0, 2, 5, 4} {
+		bag.Add(&Obj{v})
+		bag.Assert()
+	}
+	for _, v := range []int64{-1, 3, 0, 5, 6} {
+		bag.Remove(v)
+		if bag.Has(v) {
+			T.Fatal()
+		}
+		bag.Assert()
+	}
+	if bag.Len() != 3 {
+		T.Fatal()
+	}
+}
+
+func TestColumnar_AppendStable(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	var bag ColumnarObj[int64, Cmp2Int]
+	var ref SortedObj[int64, Cmp2Int]
+	for i := 0; i < 20; i++ {
+		var batch []Cmp2Int
+		for j := 0; j < 50; j++ {
+			batch = append(batch, Cmp2Int{rng.Intn(100), i*100 + j})
+		}
+		bag.Append(batch...)
+		ref.Append(batch...)
+		if !slices.Equal(bag.Items(), ref) || !slices.IsSorted(bag.Keys()) {
+			T.Fatal("diverged", i)
+		}
+	}
+}
+
+func TestColumnar_Update(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	var bag ColumnarObj[int64, Cmp2Int]
+	var ref SortedObj[int64, Cmp2Int]
+	for i := 0; i < 200; i++ {
+		x := Cmp2Int{rng.Intn(50), i}
+		bag.Add(x)
+		ref.Add(x)
+	}
+	for i := 0; i < 1000; i++ {
+		pk, a := int64(rng.Intn(60)), rng.Intn(50)
+		mutate := func(x *Cmp2Int) { x.A, x.B = a, -i }
+		found, changed := bag.UpdateChanged(pk, mutate)
+		f, c := ref.UpdateChanged(pk, mutate)
+		if found != f || changed != c || !slices.Equal(bag.Items(), ref) {
+			T.Fatal(i, found, changed)
+		}
+		for j, x := range bag.Items() {
+			if bag.Keys()[j] != x.PK() {
+				T.Fatal("stale key", j)
+			}
+		}
+	}
+}
+
+func TestColumnar_Slice(T *testing.T) {
+	var bag ColumnarObj[int64, *Obj]
+	bag.Append(&Obj{0}, &Obj{1}, &Obj{2}, &Obj{3})
+	items := bag.Items()
+	testSlice := func(marker int64, max uint32, expectations ...*Obj) {
+		slice := bag.Slice(marker, max)
+		if len(slice) != len(expectations) {
+			T.Fatal()
+		}
+		for i, v := range slice {
+			if v.PK() != expectations[i].PK() {
+				T.Fatal()
+			}
+		}
+	}
+	testSlice(0, 2, items[1], items[2])
+	testSlice(-1, 2, items[0], items[1])
+	testSlice(3, 1)
+	testSlice(-1, MinSliceSize-1, items[:MinSliceSize]...)
+	testSlice(-1, MaxSliceSize+1, items...)
+}
+
+// Assert panics if Check returns an error
+func (s *ColumnarObj[PkType, T]) Assert() {
+	if err := s.Check(); err != nil {
+		panic(err)
+	}
+}
+
+// Check validates the ordering, the unicity and the consistency of both columns
+func (s *ColumnarObj[PkType, T]) Check() error {
+	if len(s.keys) != len(s.items) {
+		return ErrUnsorted
+	}
+	for i, x := range s.items {
+		if x.PK() != s.keys[i] {
+			return ErrUnsorted
+		}
+	}
+	if !sort.IsSorted(s) {
+		return ErrUnsorted
+	}
+	for i := 1; i < len(s.keys); i++ {
+		if s.keys[i-1] == s.keys[i] {
+			return ErrDuplicates
+		}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ package bags
 
 import (
 	"errors"
+	"slices"
 )
 
 const (
@@ -18,6 +19,40 @@ const (
 )
 
 var (
-	errUnsorted   = errors.New("unsorted")
-	errDuplicates = errors.New("duplicates")
+	// ErrUnsorted reports items that are not in ascending order
+	ErrUnsorted = errors.New("unsorted")
+
+	// ErrDuplicates reports several items with the same value or PRIMARY KEY
+	ErrDuplicates = errors.New("duplicates")
 )
+
+// boundSliceSize bounds the size of a slice requested to the Slice methods
+// by MinSliceSize and MaxSliceSize
+func boundSliceSize(max uint32) uint32 {
+	if max < MinSliceSize {
+		return MinSliceSize
+	} else if max > MaxSliceSize {
+		return MaxSliceSize
+	}
+	return max
+}
+
+// reposition moves the item at the given position, whose ordering changed,
+// right after the items not greater than it, by shifting the items in
+// between. The other items must be sorted. upper is an upper-bound
+// comparator like cmpUpperBound.
+func reposition[T any](s []T, idx int, upper func(item, target T) int) int {
+	x := s[idx]
+	if idx+1 < len(s) && upper(s[idx+1], x) < 0 {
+		// Moves toward the end
+		j, _ := slices.BinarySearchFunc(s[idx+1:], x, upper)
+		copy(s[idx:idx+j], s[idx+1:idx+1+j])
+		s[idx+j] = x
+		return idx + j
+	}
+	// Moves toward the start, if necessary
+	j, _ := slices.BinarySearchFunc(s[:idx], x, upper)
+	copy(s[j+1:idx+1], s[j:idx])
+	s[j] = x
+	return j
+}
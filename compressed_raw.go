@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"encoding/binary"
+	"sort"
+	"unsafe"
+)
+
+// CompressedBlockSize is the number of integers per block of a CompressedRaw
+const CompressedBlockSize = 128
+
+// CompressedRaw implements a read-only sorted array of unsigned integers,
+// compressed in blocks of delta-encoded varints.
+// A skip index keeps the first value and the offset of each block, so that
+// a lookup binary-searches the skip index and then decodes a single block.
+// Small gaps between consecutive values cost a single byte.
+// A CompressedRaw is built with CompressRaw.
+type CompressedRaw[T Unsigned] struct {
+	// firsts holds the first value of each block
+	firsts []T
+	// offsets holds the position in data of the deltas of each block
+	offsets []int
+	// data holds the uvarint deltas between consecutive values in a block
+	data []byte
+	size int
+}
+
+// CompressRaw returns a compressed copy of the sorted array
+func CompressRaw[T Unsigned](s SortedRaw[T]) *CompressedRaw[T] {
+	c := &CompressedRaw[T]{size: len(s)}
+	for i, x := range s {
+		if i%CompressedBlockSize == 0 {
+			c.firsts = append(c.firsts, x)
+			c.offsets = append(c.offsets, len(c.data))
+		} else {
+			c.data = binary.AppendUvarint(c.data, uint64(x-s[i-1]))
+		}
+	}
+	return c
+}
+
+// Len returns the number of items in the array
+func (c *CompressedRaw[T]) Len() int { return c.size }
+
+// SizeBytes returns the memory used by the compressed content
+func (c *CompressedRaw[T]) SizeBytes() int {
+	var zero T
+	return len(c.data) + int(unsafe.Sizeof(0))*len(c.offsets) + int(unsafe.Sizeof(zero))*len(c.firsts)
+}
+
+// compressedCursor iterates over the values of a CompressedRaw
+type compressedCursor[T Unsigned] struct {
+	c     *CompressedRaw[T]
+	rank  int
+	off   int
+	value T
+}
+
+func (it *compressedCursor[T]) valid() bool { return it.rank < it.c.size }
+
+func (it *compressedCursor[T]) next() {
+	it.rank++
+	if !it.valid() {
+		return
+	}
+	if it.rank%CompressedBlockSize == 0 {
+		b := it.rank / CompressedBlockSize
+		it.value, it.off = it.c.firsts[b], it.c.offsets[b]
+		return
+	}
+	delta, n := binary.Uvarint(it.c.data[it.off:])
+	it.value += T(delta)
+	it.off += n
+}
+
+// seek returns a cursor on the first value for which before returns false.
+// Only the last block whose first value doesn't match is decoded.
+func (c *CompressedRaw[T]) seek(before func(x T) bool) compressedCursor[T] {
+	b := sort.Search(len(c.firsts), func(i int) bool { return !before(c.firsts[i]) }) - 1
+	if b < 0 {
+		b = 0
+	}
+	it := compressedCursor[T]{c: c, rank: b * CompressedBlockSize}
+	if it.valid() {
+		it.value, it.off = c.firsts[b], c.offsets[b]
+	}
+	for it.valid() && before(it.value) {
+		it.next()
+	}
+	return it
+}
+
+// GetIndex returns -1 if no item of the array is identical to the given value, or the position
+// of the first element.
+func (c *CompressedRaw[T]) GetIndex(id T) int {
+	it := c.seek(func(x T) bool { return x < id })
+	if it.valid() && it.value == id {
+		return it.rank
+	}
+	return -1
+}
+
+// Get tests for the presence of the raw item in the current set and returns
+// a copy of the entity of it is present.
+func (c *CompressedRaw[T]) Get(id T) (out T, ok bool) {
+	if c.GetIndex(id) >= 0 {
+		return id, true
+	}
+	return out, false
+}
+
+// Has tests for the presence of the raw item in the current set
+func (c *CompressedRaw[T]) Has(id T) bool { return c.GetIndex(id) >= 0 }
+
+// Rank returns the number of items strictly lesser than the given value
+func (c *CompressedRaw[T]) Rank(id T) int {
+	return c.seek(func(x T) bool { return x < id }).rank
+}
+
+// Slice returns at most max items strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (c *CompressedRaw[T]) Slice(marker T, max uint32) []T {
+	out := make([]T, 0)
+	max = boundSliceSize(max)
+	for it := c.seek(func(x T) bool { return x <= marker }); it.valid() && uint32(len(out)) < max; it.next() {
+		out = append(out, it.value)
+	}
+	return out
+}
+
+// Each calls the hook on every item, in order, until the hook returns false
+func (c *CompressedRaw[T]) Each(hook func(x T) bool) {
+	it := compressedCursor[T]{c: c}
+	if it.valid() {
+		it.value = c.firsts[0]
+	}
+	for ; it.valid() && hook(it.value); it.next() {
+	}
+}
+
+// Decompress returns an uncompressed copy of the array
+func (c *CompressedRaw[T]) Decompress() SortedRaw[T] {
+	out := make(SortedRaw[T], 0, c.size)
+	c.Each(func(x T) bool {
+		out = append(out, x)
+		return true
+	})
+	return out
+}
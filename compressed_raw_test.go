@@ -0,0 +1,73 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCompressed_Lookup(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	for _, n := range []int{0, 1, CompressedBlockSize, 5*CompressedBlockSize + 3} {
+		var bag SortedRaw[uint32]
+		for i := 0; i < n; i++ {
+			bag.Add(uint32(rng.Intn(4 * n)))
+		}
+		c := CompressRaw(bag)
+		if c.Len() != n {
+			T.Fatal()
+		}
+		for v := uint32(0); v < uint32(4*n+2); v++ {
+			if c.GetIndex(v) != bag.GetIndex(v) {
+				T.Fatal("n", n, "v", v, c.GetIndex(v), bag.GetIndex(v))
+			}
+			if x, ok := c.Get(v); ok != bag.Has(v) || (ok && x != v) {
+				T.Fatal("n", n, "v", v)
+			}
+			expected, got := bag.Slice(v, 7), c.Slice(v, 7)
+			if len(expected) != len(got) {
+				T.Fatal("n", n, "v", v)
+			}
+			for i := range expected {
+				if expected[i] != got[i] {
+					T.Fatal("n", n, "v", v)
+				}
+			}
+		}
+		decompressed := c.Decompress()
+		if len(decompressed) != len(bag) {
+			T.Fatal()
+		}
+		for i := range bag {
+			if bag[i] != decompressed[i] {
+				T.Fatal()
+			}
+		}
+	}
+}
+
+func TestCompressed_Duplicates(T *testing.T) {
+	var bag SortedRaw[uint64]
+	for i := 0; i < 3*CompressedBlockSize; i++ {
+		bag.Add(uint64(i / 200))
+	}
+	c := CompressRaw(bag)
+	if c.GetIndex(0) != 0 || c.GetIndex(1) != 200 || c.Rank(2) != 3*CompressedBlockSize || c.Has(2) {
+		T.Fatal()
+	}
+}
+
+func TestCompressed_Size(T *testing.T) {
+	var bag SortedRaw[uint64]
+	for i := uint64(0); i < 10000; i++ {
+		bag = append(bag, 1<<40+3*i)
+	}
+	c := CompressRaw(bag)
+	if c.SizeBytes() > len(bag)*2 {
+		T.Fatal("size", c.SizeBytes())
+	}
+}
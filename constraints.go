@@ -5,6 +5,10 @@
 
 package bags
 
+import (
+	"cmp"
+)
+
 // Signed is a constraint that permits any signed integer type.
 // If future releases of Go add new predeclared signed integer types,
 // this constraint will be modified to include them.
@@ -42,8 +46,5 @@ type Complex interface {
 
 // Ordered is a constraint that permits any ordered type: any type
 // that supports the operators < <= >= >.
-// If future releases of Go add new ordered types,
-// this constraint will be modified to include them.
-type Ordered interface {
-	Integer | Float | ~string
-}
+// Ordered is an alias of cmp.Ordered, kept for compatibility.
+type Ordered = cmp.Ordered
@@ -0,0 +1,59 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"fmt"
+	"slices"
+)
+
+// DecodeMode tells how the decoders deal with the ordering of the decoded items
+type DecodeMode int
+
+const (
+	// DecodeSort sorts the decoded items. It is the mode of the decoders that
+	// cannot take a DecodeMode as a parameter, e.g. UnmarshalJSON.
+	DecodeSort DecodeMode = iota
+
+	// DecodeStrict rejects unsorted or duplicated items with an *OrderError
+	DecodeStrict
+
+	// DecodeTrust keeps the decoded items as is. The bag is broken if the
+	// input wasn't sorted.
+	DecodeTrust
+)
+
+// OrderError reports the first decoded item breaking the ordering of a bag
+type OrderError struct {
+	// Index is the position of the faulty item in the decoded input
+	Index int
+	// Err is either ErrUnsorted or ErrDuplicates
+	Err error
+}
+
+func (e *OrderError) Error() string { return fmt.Sprintf("item %d: %v", e.Index, e.Err) }
+
+func (e *OrderError) Unwrap() error { return e.Err }
+
+// restoreOrder applies the decode mode to the decoded items
+func restoreOrder[T any](items []T, mode DecodeMode, compare func(a, b T) int) error {
+	switch mode {
+	case DecodeTrust:
+		return nil
+	case DecodeStrict:
+		for i := 1; i < len(items); i++ {
+			if c := compare(items[i-1], items[i]); c > 0 {
+				return &OrderError{Index: i, Err: ErrUnsorted}
+			} else if c == 0 {
+				return &OrderError{Index: i, Err: ErrDuplicates}
+			}
+		}
+		return nil
+	default:
+		slices.SortStableFunc(items, compare)
+		return nil
+	}
+}
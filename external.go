@@ -0,0 +1,306 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"unsafe"
+)
+
+// DedupMode tells how the ExternalSorter deals with items sharing the same key
+type DedupMode int
+
+const (
+	// DedupNone keeps all the items, in their order of insertion
+	DedupNone DedupMode = iota
+	// DedupKeepFirst only keeps the first item inserted for each key
+	DedupKeepFirst
+	// DedupKeepLast only keeps the last item inserted for each key
+	DedupKeepLast
+)
+
+// ErrSorterDone reports the use of an ExternalSorter already merged or closed
+var ErrSorterDone = errors.New("sorter done")
+
+// ExternalSorter sorts more items than the memory can hold.
+// The items are accumulated into an in-memory run until the memory budget is
+// reached, then the run is sorted in place and spilled to a temporary file.
+// The capacity of the run never exceeds the budget. Merge
+// finally streams the k-way merge of all the runs.
+// The sort is stable: the items sharing the same key are produced in their
+// order of insertion.
+type ExternalSorter[K Ordered, T any] struct {
+	codec Codec[T]
+	key   func(x T) K
+
+	// Budget is the memory in bytes the in-memory run may use
+	Budget int
+	// Dir is the directory of the temporary files, os.TempDir() if empty
+	Dir string
+	// Dedup is applied during the merge
+	Dedup DedupMode
+	// Sizer optionally reports the memory pointed to by an item, in
+	// addition to the size of the item itself.
+	Sizer func(x *T) int
+
+	run     []T
+	runSize int
+	spilled []*os.File
+	done    bool
+}
+
+// NewExternalSorterRaw returns a sorter of raw items, ordered like a SortedRaw
+func NewExternalSorterRaw[T Ordered](codec Codec[T], budget int) *ExternalSorter[T, T] {
+	return NewExternalSorter(codec, func(x T) T { return x }, budget)
+}
+
+// NewExternalSorterObj returns a sorter of objects, ordered by PRIMARY KEY like a SortedObj
+func NewExternalSorterObj[PkType Ordered, T WithPK[PkType]](codec Codec[T], budget int) *ExternalSorter[PkType, T] {
+	return NewExternalSorter(codec, func(x T) PkType { return x.PK() }, budget)
+}
+
+// NewExternalSorter returns a sorter of items ordered by the given key
+func NewExternalSorter[K Ordered, T any](codec Codec[T], key func(x T) K, budget int) *ExternalSorter[K, T] {
+	return &ExternalSorter[K, T]{codec: codec, key: key, Budget: budget}
+}
+
+func (e *ExternalSorter[K, T]) compare(a, b T) int { return cmp.Compare(e.key(a), e.key(b)) }
+
+// Add introduces an item, and spills the in-memory run if it exceeds the budget
+func (e *ExternalSorter[K, T]) Add(x T) error {
+	if e.done {
+		return ErrSorterDone
+	}
+	var zero T
+	size := int(unsafe.Sizeof(zero))
+	if len(e.run) == cap(e.run) {
+		// Grow the run like append does, but within the budget. A budget
+		// lowered below the run spills it instead.
+		n := min(max(2*cap(e.run), 16), max(e.Budget/max(size, 1), 1))
+		if n <= len(e.run) {
+			if err := e.spill(); err != nil {
+				return err
+			}
+		} else {
+			run := make([]T, len(e.run), n)
+			copy(run, e.run)
+			e.run = run
+		}
+	}
+	e.run = append(e.run, x)
+	e.runSize += size
+	if e.Sizer != nil {
+		e.runSize += e.Sizer(&x)
+	}
+	if e.runSize >= e.Budget {
+		return e.spill()
+	}
+	return nil
+}
+
+// Runs returns the number of runs spilled to temporary files
+func (e *ExternalSorter[K, T]) Runs() int { return len(e.spilled) }
+
+func (e *ExternalSorter[K, T]) sortRun() {
+	slices.SortStableFunc(e.run, e.compare)
+}
+
+func (e *ExternalSorter[K, T]) spill() error {
+	if len(e.run) == 0 {
+		return nil
+	}
+	e.sortRun()
+	f, err := os.CreateTemp(e.Dir, "bags-run-*")
+	if err != nil {
+		return err
+	}
+	if err = e.writeRun(f); err != nil {
+		// The run stays in memory, the partial file is dropped
+		return errors.Join(err, f.Close(), os.Remove(f.Name()))
+	}
+	e.spilled = append(e.spilled, f)
+	clear(e.run)
+	e.run, e.runSize = e.run[:0], 0
+	return nil
+}
+
+func (e *ExternalSorter[K, T]) writeRun(f *os.File) error {
+	w := bufio.NewWriter(f)
+	var buf []byte
+	for _, x := range e.run {
+		buf = e.codec.AppendItem(buf[:0], x)
+		if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(buf)))); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := f.Seek(0, io.SeekStart)
+	return err
+}
+
+// Merge calls the hook on every item, in order, and then releases the
+// temporary files. It stops at the first error returned by the hook.
+// The sorter cannot be used afterward.
+func (e *ExternalSorter[K, T]) Merge(hook func(x T) error) error {
+	if e.done {
+		return ErrSorterDone
+	}
+	defer e.Close()
+	e.done = true
+	e.sortRun()
+
+	h := &mergeHeap[K, T]{}
+	for i, f := range e.spilled {
+		src := &mergeSource[K, T]{rank: i, r: bufio.NewReader(f), codec: e.codec, key: e.key}
+		if err := h.push(src); err != nil {
+			return err
+		}
+	}
+	// The in-memory run holds the last inserted items
+	mem := &mergeSource[K, T]{rank: len(e.spilled), mem: e.run, key: e.key}
+	if err := h.push(mem); err != nil {
+		return err
+	}
+
+	var pending T
+	var hasPending bool
+	for h.Len() > 0 {
+		src := (*h)[0]
+		x := src.head
+		if err := src.next(); err == io.EOF {
+			heap.Pop(h)
+		} else if err != nil {
+			return err
+		} else {
+			heap.Fix(h, 0)
+		}
+
+		switch e.Dedup {
+		case DedupKeepFirst:
+			if hasPending && e.key(pending) == e.key(x) {
+				continue
+			}
+			pending, hasPending = x, true
+		case DedupKeepLast:
+			if hasPending && e.key(pending) != e.key(x) {
+				if err := hook(pending); err != nil {
+					return err
+				}
+			}
+			pending, hasPending = x, true
+			continue
+		}
+		if err := hook(x); err != nil {
+			return err
+		}
+	}
+	if e.Dedup == DedupKeepLast && hasPending {
+		return hook(pending)
+	}
+	return nil
+}
+
+// WriteTable merges the runs directly into the table format
+func (e *ExternalSorter[K, T]) WriteTable(w io.Writer) error {
+	tw := NewTableWriter(w, e.codec, e.key)
+	if err := e.Merge(tw.Append); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Close removes the temporary files
+func (e *ExternalSorter[K, T]) Close() error {
+	var errs []error
+	for _, f := range e.spilled {
+		errs = append(errs, f.Close(), os.Remove(f.Name()))
+	}
+	e.spilled, e.run, e.done = nil, nil, true
+	return errors.Join(errs...)
+}
+
+// mergeSource produces the items of a spilled run, or of the in-memory run
+type mergeSource[K Ordered, T any] struct {
+	rank  int
+	r     *bufio.Reader
+	codec Codec[T]
+	mem   []T
+	key   func(x T) K
+	head  T
+	buf   []byte
+}
+
+// next loads the next item as head, or returns io.EOF
+func (s *mergeSource[K, T]) next() error {
+	if s.r == nil {
+		if len(s.mem) == 0 {
+			return io.EOF
+		}
+		s.head, s.mem = s.mem[0], s.mem[1:]
+		return nil
+	}
+	size, err := binary.ReadUvarint(s.r)
+	if err != nil {
+		return err
+	}
+	if cap(s.buf) < int(size) {
+		s.buf = make([]byte, size)
+	}
+	s.buf = s.buf[:size]
+	if _, err = io.ReadFull(s.r, s.buf); err != nil {
+		return fmt.Errorf("%w: truncated run", ErrFormat)
+	}
+	s.head, err = s.codec.DecodeItem(s.buf)
+	return err
+}
+
+// mergeHeap orders the sources by the key of their head, then by rank so that
+// the merge is stable.
+type mergeHeap[K Ordered, T any] []*mergeSource[K, T]
+
+func (h mergeHeap[K, T]) Len() int { return len(h) }
+
+func (h mergeHeap[K, T]) Less(i, j int) bool {
+	if c := cmp.Compare(h[i].key(h[i].head), h[j].key(h[j].head)); c != 0 {
+		return c < 0
+	}
+	return h[i].rank < h[j].rank
+}
+
+func (h mergeHeap[K, T]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap[K, T]) Push(x any) { *h = append(*h, x.(*mergeSource[K, T])) }
+
+func (h *mergeHeap[K, T]) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// push loads the first item of the source and adds it unless it is empty
+func (h *mergeHeap[K, T]) push(src *mergeSource[K, T]) error {
+	if err := src.next(); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	heap.Push(h, src)
+	return nil
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestExternal_Raw(T *testing.T) {
+	dir := T.TempDir()
+	sorter := NewExternalSorterRaw(RawCodec[int](), 1024)
+	sorter.Dir = dir
+	items := shuffledInts(10000)
+	for _, x := range items {
+		if err := sorter.Add(x); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if sorter.Runs() < 2 {
+		T.Fatal(sorter.Runs())
+	}
+
+	var out SortedRaw[int]
+	if err := sorter.Merge(func(x int) error { out = append(out, x); return nil }); err != nil {
+		T.Fatal(err)
+	}
+	if len(out) != len(items) || !slices.IsSorted(out) {
+		T.Fatal(len(out))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		T.Fatal("temporary files left", len(entries))
+	}
+	if err := sorter.Add(1); !errors.Is(err, ErrSorterDone) {
+		T.Fatal(err)
+	}
+}
+
+func TestExternal_Budget(T *testing.T) {
+	const budget = 1 << 20
+	sorter := NewExternalSorterRaw(RawCodec[int](), budget)
+	sorter.Dir = T.TempDir()
+	defer sorter.Close()
+	for _, x := range shuffledInts(budget/8 - 1) {
+		if err := sorter.Add(x); err != nil {
+			T.Fatal(err)
+		}
+		if cap(sorter.run)*8 > budget {
+			T.Fatal("run beyond the budget", cap(sorter.run))
+		}
+	}
+	if sorter.Runs() != 0 {
+		T.Fatal(sorter.Runs())
+	}
+
+	// Sorting the run doesn't require another buffer
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	sorter.sortRun()
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > budget/16 {
+		T.Fatal("allocated", allocated)
+	}
+	if !slices.IsSorted(sorter.run) {
+		T.Fatal()
+	}
+}
+
+func TestExternal_Dedup(T *testing.T) {
+	for _, mode := range []DedupMode{DedupNone, DedupKeepFirst, DedupKeepLast} {
+		sorter := NewExternalSorterObj[int64](cmp2IntCodec{}, 256)
+		sorter.Dir = T.TempDir()
+		sorter.Dedup = mode
+		for i := 0; i < 1000; i++ {
+			if err := sorter.Add(Cmp2Int{A: (i * 7) % 100, B: i}); err != nil {
+				T.Fatal(err)
+			}
+		}
+		var out []Cmp2Int
+		if err := sorter.Merge(func(x Cmp2Int) error { out = append(out, x); return nil }); err != nil {
+			T.Fatal(err)
+		}
+		if mode == DedupNone {
+			if len(out) != 1000 {
+				T.Fatal(len(out))
+			}
+		} else if len(out) != 100 {
+			T.Fatal(mode, len(out))
+		}
+		for i := 1; i < len(out); i++ {
+			prev, cur := out[i-1], out[i]
+			if prev.A > cur.A || (prev.A == cur.A && prev.B >= cur.B) {
+				T.Fatal(mode, "unstable at", i, prev, cur)
+			}
+		}
+		switch mode {
+		case DedupKeepFirst:
+			if out[0].B != 0 {
+				T.Fatal(out[0])
+			}
+		case DedupKeepLast:
+			if out[0].B != 900 {
+				T.Fatal(out[0])
+			}
+		}
+	}
+}
+
+func TestExternal_Table(T *testing.T) {
+	sorter := NewExternalSorterRaw(RawCodec[string](), 512)
+	sorter.Dir = T.TempDir()
+	sorter.Dedup = DedupKeepFirst
+	for _, x := range []string{"m", "b", "z", "a", "b", "k", "z", "c"} {
+		sorter.Add(x)
+	}
+	var buf bytes.Buffer
+	if err := sorter.WriteTable(&buf); err != nil {
+		T.Fatal(err)
+	}
+	table, err := OpenTableRaw(bytes.NewReader(buf.Bytes()), int64(buf.Len()), RawCodec[string]())
+	if err != nil {
+		T.Fatal(err)
+	}
+	if table.Len() != 6 {
+		T.Fatal(table.Len())
+	}
+	if ok, err := table.Has("k"); err != nil || !ok {
+		T.Fatal(ok, err)
+	}
+}
+
+func TestExternal_HookError(T *testing.T) {
+	dir := T.TempDir()
+	sorter := NewExternalSorterRaw(RawCodec[int](), 64)
+	sorter.Dir = dir
+	for _, x := range shuffledInts(100) {
+		sorter.Add(x)
+	}
+	stop := errors.New("stop")
+	if err := sorter.Merge(func(int) error { return stop }); err != stop {
+		T.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		T.Fatal("temporary files left", len(entries))
+	}
+}
+
+func TestExternal_LowerBudget(T *testing.T) {
+	sorter := NewExternalSorterRaw(RawCodec[int](), 1<<20)
+	sorter.Dir = T.TempDir()
+	defer sorter.Close()
+	ints := shuffledInts(1000)
+	for _, x := range ints[:128] {
+		if err := sorter.Add(x); err != nil {
+			T.Fatal(err)
+		}
+	}
+
+	// The full run is spilled rather than grown past the new budget
+	sorter.Budget = 8 * 16
+	for _, x := range ints[128:] {
+		if err := sorter.Add(x); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if sorter.Runs() == 0 {
+		T.Fatal("no spill")
+	}
+	var out []int
+	if err := sorter.Merge(func(x int) error { out = append(out, x); return nil }); err != nil {
+		T.Fatal(err)
+	}
+	if len(out) != len(ints) || !slices.IsSorted(out) {
+		T.Fatal(len(out))
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// FrontCodedBucketSize is the number of strings per bucket of a FrontCodedRaw
+const FrontCodedBucketSize = 16
+
+// FrontCodedRaw implements a read-only sorted array of strings, compressed
+// with front coding: each string only stores the suffix it doesn't share
+// with the previous string.
+// The strings are grouped in buckets whose first string, the restart point,
+// is stored in full. A lookup binary-searches the restart points and then
+// decodes a single bucket.
+// A FrontCodedRaw is built with FrontCodeRaw.
+type FrontCodedRaw[T ~string] struct {
+	// restarts holds the position in data of each bucket
+	restarts []int
+	// data holds the buckets. A restart point is encoded as uvarint(len)
+	// followed by the bytes, the other strings as uvarint(shared),
+	// uvarint(len(suffix)) followed by the suffix.
+	data []byte
+	size int
+}
+
+// FrontCodeRaw returns a compressed copy of the sorted array
+func FrontCodeRaw[T ~string](s SortedRaw[T]) *FrontCodedRaw[T] {
+	f := &FrontCodedRaw[T]{size: len(s)}
+	for i, x := range s {
+		if i%FrontCodedBucketSize == 0 {
+			f.restarts = append(f.restarts, len(f.data))
+			f.data = binary.AppendUvarint(f.data, uint64(len(x)))
+			f.data = append(f.data, x...)
+			continue
+		}
+		prev := s[i-1]
+		shared := 0
+		for shared < len(prev) && shared < len(x) && prev[shared] == x[shared] {
+			shared++
+		}
+		f.data = binary.AppendUvarint(f.data, uint64(shared))
+		f.data = binary.AppendUvarint(f.data, uint64(len(x)-shared))
+		f.data = append(f.data, x[shared:]...)
+	}
+	return f
+}
+
+// Len returns the number of items in the array
+func (f *FrontCodedRaw[T]) Len() int { return f.size }
+
+// SizeBytes returns the memory used by the compressed content
+func (f *FrontCodedRaw[T]) SizeBytes() int {
+	return len(f.data) + int(unsafe.Sizeof(0))*len(f.restarts)
+}
+
+// restart returns the string stored in full at the head of the given bucket
+func (f *FrontCodedRaw[T]) restart(b int) []byte {
+	off := f.restarts[b]
+	length, n := binary.Uvarint(f.data[off:])
+	return f.data[off+n : off+n+int(length)]
+}
+
+// frontCodedCursor iterates over the strings of a FrontCodedRaw
+type frontCodedCursor[T ~string] struct {
+	f     *FrontCodedRaw[T]
+	rank  int
+	off   int
+	value []byte
+}
+
+func (it *frontCodedCursor[T]) valid() bool { return it.rank < it.f.size }
+
+// load decodes the string at the current position
+func (it *frontCodedCursor[T]) load() {
+	data := it.f.data
+	if it.rank%FrontCodedBucketSize == 0 {
+		it.off = it.f.restarts[it.rank/FrontCodedBucketSize]
+		length, n := binary.Uvarint(data[it.off:])
+		it.off += n
+		it.value = append(it.value[:0], data[it.off:it.off+int(length)]...)
+		it.off += int(length)
+		return
+	}
+	shared, n := binary.Uvarint(data[it.off:])
+	it.off += n
+	length, n := binary.Uvarint(data[it.off:])
+	it.off += n
+	it.value = append(it.value[:shared], data[it.off:it.off+int(length)]...)
+	it.off += int(length)
+}
+
+func (it *frontCodedCursor[T]) next() {
+	if it.rank++; it.valid() {
+		it.load()
+	}
+}
+
+// seek returns a cursor on the first string for which before returns false
+func (f *FrontCodedRaw[T]) seek(before func(x string) bool) frontCodedCursor[T] {
+	b := sort.Search(len(f.restarts), func(i int) bool { return !before(string(f.restart(i))) }) - 1
+	if b < 0 {
+		b = 0
+	}
+	it := frontCodedCursor[T]{f: f, rank: b * FrontCodedBucketSize}
+	if it.valid() {
+		it.load()
+	}
+	for it.valid() && before(string(it.value)) {
+		it.next()
+	}
+	return it
+}
+
+// GetIndex returns -1 if no item of the array is identical to the given value, or the position
+// of the first element.
+func (f *FrontCodedRaw[T]) GetIndex(id T) int {
+	it := f.seek(func(x string) bool { return x < string(id) })
+	if it.valid() && string(it.value) == string(id) {
+		return it.rank
+	}
+	return -1
+}
+
+// Get tests for the presence of the raw item in the current set and returns
+// a copy of the entity of it is present.
+func (f *FrontCodedRaw[T]) Get(id T) (out T, ok bool) {
+	if f.GetIndex(id) >= 0 {
+		return id, true
+	}
+	return out, false
+}
+
+// Has tests for the presence of the raw item in the current set
+func (f *FrontCodedRaw[T]) Has(id T) bool { return f.GetIndex(id) >= 0 }
+
+// Slice returns at most max items strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (f *FrontCodedRaw[T]) Slice(marker T, max uint32) []T {
+	return f.SlicePrefix("", marker, max)
+}
+
+// SlicePrefix returns at most max items starting with the prefix and strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (f *FrontCodedRaw[T]) SlicePrefix(prefix, marker T, max uint32) []T {
+	out := make([]T, 0)
+	max = boundSliceSize(max)
+	before := func(x string) bool { return x <= string(marker) }
+	if marker < prefix {
+		before = func(x string) bool { return x < string(prefix) }
+	}
+	for it := f.seek(before); it.valid() && uint32(len(out)) < max; it.next() {
+		if !strings.HasPrefix(string(it.value), string(prefix)) {
+			break
+		}
+		out = append(out, T(it.value))
+	}
+	return out
+}
+
+// Each calls the hook on every item, in order, until the hook returns false
+func (f *FrontCodedRaw[T]) Each(hook func(x T) bool) {
+	it := frontCodedCursor[T]{f: f}
+	if it.valid() {
+		it.load()
+	}
+	for ; it.valid() && hook(T(it.value)); it.next() {
+	}
+}
+
+// Decompress returns an uncompressed copy of the array
+func (f *FrontCodedRaw[T]) Decompress() SortedRaw[T] {
+	out := make(SortedRaw[T], 0, f.size)
+	f.Each(func(x T) bool {
+		out = append(out, x)
+		return true
+	})
+	return out
+}
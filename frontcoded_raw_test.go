@@ -0,0 +1,77 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"fmt"
+	"testing"
+)
+
+func urlPaths() SortedRaw[string] {
+	var bag SortedRaw[string]
+	for i := 0; i < 50; i++ {
+		for j := 0; j < 7; j++ {
+			bag.Add(fmt.Sprintf("/api/v1/accounts/%03d/objects/%d", i, j))
+		}
+	}
+	bag.Add("")
+	bag.Add("/")
+	bag.Add("/api")
+	return bag
+}
+
+func TestFrontCoded_Lookup(T *testing.T) {
+	bag := urlPaths()
+	f := FrontCodeRaw(bag)
+	if f.Len() != len(bag) {
+		T.Fatal()
+	}
+	needles := append([]string{"0", "/a", "/api/v1/accounts/049/objects/7", "~"}, bag...)
+	for _, v := range needles {
+		if f.GetIndex(v) != bag.GetIndex(v) {
+			T.Fatal(v)
+		}
+		if x, ok := f.Get(v); ok != bag.Has(v) || (ok && x != v) {
+			T.Fatal(v)
+		}
+		expected, got := bag.Slice(v, 20), f.Slice(v, 20)
+		if len(expected) != len(got) {
+			T.Fatal(v)
+		}
+		for i := range expected {
+			if expected[i] != got[i] {
+				T.Fatal(v)
+			}
+		}
+	}
+	decompressed := f.Decompress()
+	for i := range bag {
+		if bag[i] != decompressed[i] {
+			T.Fatal(i)
+		}
+	}
+	if f.SizeBytes() >= len(bag)*len(bag[len(bag)-1]) {
+		T.Fatal("size", f.SizeBytes())
+	}
+}
+
+func TestFrontCoded_Prefix(T *testing.T) {
+	f := FrontCodeRaw(urlPaths())
+	s := f.SlicePrefix("/api/v1/accounts/012/", "", 100)
+	if len(s) != 7 || s[0] != "/api/v1/accounts/012/objects/0" || s[6] != "/api/v1/accounts/012/objects/6" {
+		T.Fatal(s)
+	}
+	s = f.SlicePrefix("/api/v1/accounts/012/", s[2], 3)
+	if len(s) != 3 || s[0] != "/api/v1/accounts/012/objects/3" {
+		T.Fatal(s)
+	}
+	if s = f.SlicePrefix("/nope", "", 10); len(s) != 0 {
+		T.Fatal(s)
+	}
+	if s = f.SlicePrefix("/api/v1/accounts/012/", "/zzz", 10); len(s) != 0 {
+		T.Fatal(s)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/bits"
+)
+
+// FrozenRaw implements a read-only sorted set of raw types.
+// The items are stored in the Eytzinger layout, i.e. the breadth-first order
+// of a complete binary search tree, so that the first levels of every lookup
+// share the same few cache lines and the next probes are at predictable
+// positions. The lookups run a branch-free descent of the implicit tree.
+// A FrozenRaw is built with SortedRaw.Freeze.
+type FrozenRaw[T Ordered] struct {
+	// tree is 1-indexed, tree[0] is unused
+	tree []T
+}
+
+// FrozenObj implements a read-only sorted set of objects providing a PRIMARY KEY.
+// The PRIMARY KEYS are cached in their own Eytzinger array, so that the lookups
+// never dereference the items.
+// A FrozenObj is built with SortedObj.Freeze.
+type FrozenObj[PkType Ordered, T WithPK[PkType]] struct {
+	// keys and items are 1-indexed, the index 0 is unused
+	keys  []PkType
+	items []T
+}
+
+// Freeze returns a read-only copy of the sorted array. The array may be
+// modified afterward without altering the FrozenRaw.
+func (s SortedRaw[T]) Freeze() *FrozenRaw[T] {
+	f := &FrozenRaw[T]{tree: make([]T, len(s)+1)}
+	eytzinger(len(s), func(k, i int) { f.tree[k] = s[i] })
+	return f
+}
+
+// Freeze returns a read-only copy of the sorted array. The array may be
+// modified afterward without altering the FrozenObj, but the items themselves
+// are shared.
+func (s SortedObj[PkType, T]) Freeze() *FrozenObj[PkType, T] {
+	f := &FrozenObj[PkType, T]{
+		keys:  make([]PkType, len(s)+1),
+		items: make([]T, len(s)+1),
+	}
+	eytzinger(len(s), func(k, i int) {
+		f.keys[k] = s[i].PK()
+		f.items[k] = s[i]
+	})
+	return f
+}
+
+// Len returns the number of items in the set
+func (f *FrozenRaw[T]) Len() int { return len(f.tree) - 1 }
+
+// Has tests for the presence of the raw item in the current set
+func (f *FrozenRaw[T]) Has(id T) bool {
+	k := eytzingerSearch(f.tree, func(x T) bool { return x < id })
+	return k > 0 && f.tree[k] == id
+}
+
+// Get tests for the presence of the raw item in the current set and returns
+// a copy of the entity of it is present.
+func (f *FrozenRaw[T]) Get(id T) (out T, ok bool) {
+	k := eytzingerSearch(f.tree, func(x T) bool { return x < id })
+	if k > 0 && f.tree[k] == id {
+		return f.tree[k], true
+	}
+	return out, false
+}
+
+// Slice returns at most max items strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (f *FrozenRaw[T]) Slice(marker T, max uint32) []T {
+	out := make([]T, 0)
+	n := len(f.tree) - 1
+	k := eytzingerSearch(f.tree, func(x T) bool { return x <= marker })
+	for max = boundSliceSize(max); k > 0 && uint32(len(out)) < max; k = eytzingerNext(k, n) {
+		out = append(out, f.tree[k])
+	}
+	return out
+}
+
+// Len returns the number of items in the set
+func (f *FrozenObj[PkType, T]) Len() int { return len(f.keys) - 1 }
+
+// Has tests for the presence of an item in the set, given the primary key of the item
+func (f *FrozenObj[PkType, T]) Has(id PkType) bool {
+	k := eytzingerSearch(f.keys, func(x PkType) bool { return x < id })
+	return k > 0 && f.keys[k] == id
+}
+
+// Get returns the first item with the given PRIMARY KEY
+func (f *FrozenObj[PkType, T]) Get(id PkType) (out T, ok bool) {
+	k := eytzingerSearch(f.keys, func(x PkType) bool { return x < id })
+	if k > 0 && f.keys[k] == id {
+		return f.items[k], true
+	}
+	return out, false
+}
+
+// Slice returns at most max items whose PRIMARY KEY is strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (f *FrozenObj[PkType, T]) Slice(marker PkType, max uint32) []T {
+	out := make([]T, 0)
+	n := len(f.keys) - 1
+	k := eytzingerSearch(f.keys, func(x PkType) bool { return x <= marker })
+	for max = boundSliceSize(max); k > 0 && uint32(len(out)) < max; k = eytzingerNext(k, n) {
+		out = append(out, f.items[k])
+	}
+	return out
+}
+
+// eytzinger calls place(k, i) to store the i-th sorted item at the position
+// k of a 1-indexed Eytzinger array of n items.
+func eytzinger(n int, place func(k, i int)) {
+	i := 0
+	var build func(k int)
+	build = func(k int) {
+		if k <= n {
+			build(2 * k)
+			place(k, i)
+			i++
+			build(2*k + 1)
+		}
+	}
+	build(1)
+}
+
+// eytzingerSearch returns the position of the first item for which before
+// returns false, or 0 if there is none. The predicate must be monotonic
+// over the sorted order.
+func eytzingerSearch[T any](tree []T, before func(x T) bool) int {
+	k, n := 1, len(tree)-1
+	for k <= n {
+		k = 2*k + b2i(before(tree[k]))
+	}
+	// Strip the trailing right turns and the last left turn
+	return k >> (bits.TrailingZeros(^uint(k)) + 1)
+}
+
+// eytzingerNext returns the position of the in-order successor of the item at
+// position k, or 0 if k is the last item.
+func eytzingerNext(k, n int) int {
+	if 2*k+1 <= n {
+		k = 2*k + 1
+		for 2*k <= n {
+			k = 2 * k
+		}
+		return k
+	}
+	for k&1 == 1 {
+		k >>= 1
+	}
+	return k >> 1
+}
+
+// b2i is recognized by the compiler and turned into a conditional move
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFrozen_Raw(T *testing.T) {
+	for n := 0; n < 70; n++ {
+		var bag SortedRaw[int]
+		for i := 0; i < n; i++ {
+			bag.Add(2 * i)
+		}
+		frozen := bag.Freeze()
+		if frozen.Len() != n {
+			T.Fatal()
+		}
+		for v := -1; v <= 2*n; v++ {
+			if frozen.Has(v) != bag.Has(v) {
+				T.Fatal("n", n, "v", v)
+			}
+			x, ok := frozen.Get(v)
+			if y, ok2 := bag.Get(v); ok != ok2 || x != y {
+				T.Fatal("n", n, "v", v)
+			}
+			for _, max := range []uint32{0, 3, MaxSliceSize + 1} {
+				expected, got := bag.Slice(v, max), frozen.Slice(v, max)
+				if len(expected) != len(got) {
+					T.Fatal("n", n, "marker", v, "max", max)
+				}
+				for i := range expected {
+					if expected[i] != got[i] {
+						T.Fatal("n", n, "marker", v, "max", max)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestFrozen_Obj(T *testing.T) {
+	bag := SortedObj[int64, *Obj]{&Obj{0}, &Obj{1}, &Obj{1}, &Obj{3}}
+	frozen := bag.Freeze()
+	bag.Remove(1)
+	if !frozen.Has(1) || frozen.Has(2) || frozen.Len() != 4 {
+		T.Fatal()
+	}
+	if x, ok := frozen.Get(3); !ok || x.PK() != 3 {
+		T.Fatal()
+	}
+	if s := frozen.Slice(0, 10); len(s) != 3 || s[0].PK() != 1 || s[1].PK() != 1 || s[2].PK() != 3 {
+		T.Fatal()
+	}
+}
+
+func benchmarkNeedles(n int) (SortedRaw[uint64], []uint64) {
+	rng := rand.New(rand.NewSource(0))
+	bag := make(SortedRaw[uint64], 0, n)
+	for i := 0; i < n; i++ {
+		bag = append(bag, rng.Uint64())
+	}
+	bag.Append()
+	needles := make([]uint64, 1024)
+	for i := range needles {
+		if i%2 == 0 {
+			needles[i] = bag[rng.Intn(n)]
+		} else {
+			needles[i] = rng.Uint64()
+		}
+	}
+	return bag, needles
+}
+
+func BenchmarkFrozen_Has(b *testing.B) {
+	bag, needles := benchmarkNeedles(1 << 20)
+	frozen := bag.Freeze()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		frozen.Has(needles[i%len(needles)])
+	}
+}
+
+func BenchmarkFrozen_SortedHas(b *testing.B) {
+	bag, needles := benchmarkNeedles(1 << 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bag.Has(needles[i%len(needles)])
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrNoCheckpoint reports a checkpoint unknown or no longer reachable, either
+// dropped with the oldest history or discarded with the redo history.
+var ErrNoCheckpoint = errors.New("no such checkpoint")
+
+// JournalCmp modifies a SortedCmp and records the inverse of each operation
+// so that the operations can be undone and redone.
+// Each operation is journaled as the positional insertions and deletions it
+// made, so that Undo restores the exact previous array, including the order
+// of the items that compare equal.
+// The array must only be modified through the journal while it is in use.
+type JournalCmp[T WithCompare[T]] struct {
+	bag  *SortedCmp[T]
+	undo []journalOp[T]
+	redo []journalOp[T]
+
+	// MaxDepth bounds the number of operations that can be undone, the
+	// oldest ones are forgotten. Zero means no bound.
+	MaxDepth int
+
+	lastID      uint64
+	baseID      uint64
+	checkpoints map[string]uint64
+}
+
+// journalOp is a journaled operation, identified by a sequence number
+type journalOp[T WithCompare[T]] struct {
+	id    uint64
+	steps []journalStep[T]
+}
+
+// journalStep is the insertion of an item at a position, or its deletion
+type journalStep[T WithCompare[T]] struct {
+	pos    int
+	item   T
+	insert bool
+}
+
+// Journal returns a journal of the modifications of the array, keeping at
+// most depth operations to undo, or an unbounded history if depth is zero.
+func (s *SortedCmp[T]) Journal(depth int) *JournalCmp[T] {
+	return &JournalCmp[T]{bag: s, MaxDepth: depth, checkpoints: make(map[string]uint64)}
+}
+
+// Add introduces a new item in the array, after the items that compare equal
+func (j *JournalCmp[T]) Add(a T) {
+	j.record(j.insert(nil, a))
+}
+
+// Append introduces several items in the array, in their order, after the
+// items that compare equal. The operation is undone as a whole.
+func (j *JournalCmp[T]) Append(a ...T) {
+	if len(a) == 0 {
+		return
+	}
+	steps := make([]journalStep[T], 0, len(a))
+	for _, x := range a {
+		steps = j.insert(steps, x)
+	}
+	j.record(steps)
+}
+
+// Remove removes the first item that compares equal to the given one, and
+// returns false if there is none.
+func (j *JournalCmp[T]) Remove(a T) bool {
+	steps := j.delete(nil, a)
+	if steps == nil {
+		return false
+	}
+	j.record(steps)
+	return true
+}
+
+// Update replaces the first item that compares equal to old by the updated
+// item, placed after the items comparing equal to it. It returns false and
+// journals nothing if there is no such item.
+func (j *JournalCmp[T]) Update(old, updated T) bool {
+	steps := j.delete(nil, old)
+	if steps == nil {
+		return false
+	}
+	j.record(j.insert(steps, updated))
+	return true
+}
+
+// CanUndo tells if an operation can be undone
+func (j *JournalCmp[T]) CanUndo() bool { return len(j.undo) > 0 }
+
+// CanRedo tells if an undone operation can be redone
+func (j *JournalCmp[T]) CanRedo() bool { return len(j.redo) > 0 }
+
+// Undo reverts the last operation not yet undone, and returns false if there is none
+func (j *JournalCmp[T]) Undo() bool {
+	if len(j.undo) == 0 {
+		return false
+	}
+	op := j.undo[len(j.undo)-1]
+	j.undo = j.undo[:len(j.undo)-1]
+	for i := len(op.steps) - 1; i >= 0; i-- {
+		j.apply(op.steps[i], true)
+	}
+	j.redo = append(j.redo, op)
+	return true
+}
+
+// Redo applies again the last undone operation, and returns false if there is none
+func (j *JournalCmp[T]) Redo() bool {
+	if len(j.redo) == 0 {
+		return false
+	}
+	op := j.redo[len(j.redo)-1]
+	j.redo = j.redo[:len(j.redo)-1]
+	for _, step := range op.steps {
+		j.apply(step, false)
+	}
+	j.undo = append(j.undo, op)
+	return true
+}
+
+// Checkpoint names the current state of the array, replacing any previous
+// checkpoint with the same name.
+func (j *JournalCmp[T]) Checkpoint(name string) { j.checkpoints[name] = j.current() }
+
+// Restore undoes or redoes the operations until the array is in the state
+// named by the checkpoint. It returns ErrNoCheckpoint if the state is not
+// reachable anymore, the array is then left unchanged.
+func (j *JournalCmp[T]) Restore(name string) error {
+	target, ok := j.checkpoints[name]
+	if !ok {
+		return ErrNoCheckpoint
+	}
+	if target == j.baseID || slices.ContainsFunc(j.undo, func(op journalOp[T]) bool { return op.id == target }) {
+		for j.current() != target {
+			j.Undo()
+		}
+		return nil
+	}
+	if slices.ContainsFunc(j.redo, func(op journalOp[T]) bool { return op.id == target }) {
+		for j.current() != target {
+			j.Redo()
+		}
+		return nil
+	}
+	return ErrNoCheckpoint
+}
+
+// current returns the identifier of the current state, i.e. of the last
+// operation applied.
+func (j *JournalCmp[T]) current() uint64 {
+	if len(j.undo) == 0 {
+		return j.baseID
+	}
+	return j.undo[len(j.undo)-1].id
+}
+
+func (j *JournalCmp[T]) record(steps []journalStep[T]) {
+	j.lastID++
+	j.undo = append(j.undo, journalOp[T]{id: j.lastID, steps: steps})
+	clear(j.redo)
+	j.redo = j.redo[:0]
+	if j.MaxDepth > 0 && len(j.undo) > j.MaxDepth {
+		drop := len(j.undo) - j.MaxDepth
+		j.baseID = j.undo[drop-1].id
+		clear(j.undo[:drop])
+		j.undo = j.undo[drop:]
+	}
+}
+
+// insert adds the item to the array and appends the step to steps
+func (j *JournalCmp[T]) insert(steps []journalStep[T], a T) []journalStep[T] {
+	pos, _ := slices.BinarySearchFunc(*j.bag, a, cmpUpperBound[T])
+	step := journalStep[T]{pos: pos, item: a, insert: true}
+	j.apply(step, false)
+	return append(steps, step)
+}
+
+// delete removes the first item equal to a from the array and appends the
+// step to steps, or returns nil if there is no such item.
+func (j *JournalCmp[T]) delete(steps []journalStep[T], a T) []journalStep[T] {
+	pos := j.bag.GetIndex(a)
+	if pos < 0 {
+		return nil
+	}
+	step := journalStep[T]{pos: pos, item: (*j.bag)[pos]}
+	j.apply(step, false)
+	return append(steps, step)
+}
+
+// apply performs the step, or its inverse
+func (j *JournalCmp[T]) apply(step journalStep[T], inverse bool) {
+	if step.insert != inverse {
+		*j.bag = slices.Insert(*j.bag, step.pos, step.item)
+	} else {
+		*j.bag = slices.Delete(*j.bag, step.pos, step.pos+1)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"cmp"
+	"errors"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// cmpTagged only compares on V, the tag tells the items comparing equal apart
+type cmpTagged struct {
+	V, Tag int
+}
+
+func (x cmpTagged) Compare(o cmpTagged) int { return cmp.Compare(x.V, o.V) }
+
+func TestJournal_UndoRedo(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	var bag SortedCmp[cmpTagged]
+	j := bag.Journal(0)
+	states := []SortedCmp[cmpTagged]{nil}
+	for i := 0; i < 500; i++ {
+		v := rng.Intn(20)
+		switch rng.Intn(4) {
+		case 0:
+			j.Add(cmpTagged{v, i})
+		case 1:
+			j.Append(cmpTagged{v, i}, cmpTagged{rng.Intn(20), i}, cmpTagged{v, -i})
+		case 2:
+			if j.Remove(cmpTagged{V: v}) == (states[len(states)-1].GetIndex(cmpTagged{V: v}) < 0) {
+				T.Fatal("remove", v)
+			}
+		default:
+			j.Update(cmpTagged{V: v}, cmpTagged{rng.Intn(20), i})
+		}
+		if !slices.Equal(bag, states[len(states)-1]) {
+			states = append(states, slices.Clone(bag))
+		}
+		if len(j.undo) != len(states)-1 {
+			T.Fatal("journaled no-op")
+		}
+	}
+
+	for i := len(states) - 1; i > 0; i-- {
+		if !slices.Equal(bag, states[i]) {
+			T.Fatal("undo", i)
+		}
+		if !j.Undo() {
+			T.Fatal("undo", i)
+		}
+	}
+	if len(bag) != 0 || j.Undo() {
+		T.Fatal("undo all")
+	}
+	for i := 1; i < len(states); i++ {
+		if !j.Redo() || !slices.Equal(bag, states[i]) {
+			T.Fatal("redo", i)
+		}
+	}
+	if j.Redo() {
+		T.Fatal("redo all")
+	}
+}
+
+func TestJournal_Checkpoints(T *testing.T) {
+	var bag SortedCmp[cmpTagged]
+	j := bag.Journal(0)
+	j.Add(cmpTagged{1, 0})
+	j.Checkpoint("one")
+	j.Append(cmpTagged{2, 0}, cmpTagged{1, 1})
+	j.Checkpoint("two")
+	j.Update(cmpTagged{V: 1}, cmpTagged{3, 0})
+	three := slices.Clone(bag)
+
+	if err := j.Restore("one"); err != nil || !slices.Equal(bag, SortedCmp[cmpTagged]{{1, 0}}) {
+		T.Fatal(err, bag)
+	}
+	if err := j.Restore("two"); err != nil || !slices.Equal(bag, SortedCmp[cmpTagged]{{1, 0}, {1, 1}, {2, 0}}) {
+		T.Fatal(err, bag)
+	}
+	if !j.Redo() || !slices.Equal(bag, three) {
+		T.Fatal(bag)
+	}
+	if err := j.Restore("missing"); !errors.Is(err, ErrNoCheckpoint) {
+		T.Fatal(err)
+	}
+
+	// A new operation after an undo discards the redo history
+	j.Restore("one")
+	j.Add(cmpTagged{5, 0})
+	if err := j.Restore("two"); !errors.Is(err, ErrNoCheckpoint) || !slices.Equal(bag, SortedCmp[cmpTagged]{{1, 0}, {5, 0}}) {
+		T.Fatal(err, bag)
+	}
+}
+
+func TestJournal_Depth(T *testing.T) {
+	var bag SortedCmp[cmpTagged]
+	j := bag.Journal(3)
+	j.Checkpoint("empty")
+	for i := 0; i < 5; i++ {
+		j.Add(cmpTagged{i, 0})
+		j.Checkpoint("last")
+	}
+	undone := 0
+	for j.Undo() {
+		undone++
+	}
+	if undone != 3 || len(bag) != 2 {
+		T.Fatal(undone, bag)
+	}
+	if err := j.Restore("empty"); !errors.Is(err, ErrNoCheckpoint) || len(bag) != 2 {
+		T.Fatal(err)
+	}
+	if err := j.Restore("last"); err != nil || len(bag) != 5 {
+		T.Fatal(err, bag)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"cmp"
+	"encoding/json"
+)
+
+// MarshalJSON encodes the array as a JSON array, in ascending order
+func (s SortedRaw[T]) MarshalJSON() ([]byte, error) { return json.Marshal([]T(s)) }
+
+// UnmarshalJSON decodes a JSON array and sorts it, as with DecodeSort
+func (s *SortedRaw[T]) UnmarshalJSON(data []byte) error {
+	return s.UnmarshalJSONMode(data, DecodeSort)
+}
+
+// UnmarshalJSONMode decodes a JSON array according to the given mode.
+// The array is left unchanged if an error is returned.
+func (s *SortedRaw[T]) UnmarshalJSONMode(data []byte, mode DecodeMode) error {
+	items, err := unmarshalJSON(data, mode, cmp.Compare[T])
+	if err == nil {
+		*s = items
+	}
+	return err
+}
+
+// MarshalJSON encodes the array as a JSON array, in ascending order
+func (s SortedObj[PkType, T]) MarshalJSON() ([]byte, error) { return json.Marshal([]T(s)) }
+
+// UnmarshalJSON decodes a JSON array and sorts it, as with DecodeSort
+func (s *SortedObj[PkType, T]) UnmarshalJSON(data []byte) error {
+	return s.UnmarshalJSONMode(data, DecodeSort)
+}
+
+// UnmarshalJSONMode decodes a JSON array according to the given mode.
+// The array is left unchanged if an error is returned.
+func (s *SortedObj[PkType, T]) UnmarshalJSONMode(data []byte, mode DecodeMode) error {
+	items, err := unmarshalJSON(data, mode, objCompare[PkType, T])
+	if err == nil {
+		*s = items
+	}
+	return err
+}
+
+// MarshalJSON encodes the array as a JSON array, in ascending order
+func (s SortedCmp[T]) MarshalJSON() ([]byte, error) { return json.Marshal([]T(s)) }
+
+// UnmarshalJSON decodes a JSON array and sorts it, as with DecodeSort
+func (s *SortedCmp[T]) UnmarshalJSON(data []byte) error {
+	return s.UnmarshalJSONMode(data, DecodeSort)
+}
+
+// UnmarshalJSONMode decodes a JSON array according to the given mode.
+// The array is left unchanged if an error is returned.
+func (s *SortedCmp[T]) UnmarshalJSONMode(data []byte, mode DecodeMode) error {
+	items, err := unmarshalJSON(data, mode, cmpCompare[T])
+	if err == nil {
+		*s = items
+	}
+	return err
+}
+
+func unmarshalJSON[T any](data []byte, mode DecodeMode, compare func(a, b T) int) ([]T, error) {
+	var items []T
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	if err := restoreOrder(items, mode, compare); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
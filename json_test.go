@@ -0,0 +1,81 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestJSON_RoundTrip(T *testing.T) {
+	raw := SortedRaw[string]{"a", "b", "c"}
+	obj := SortedObj[int64, Cmp2Int]{{1, 2}, {3, 4}}
+	cmps := SortedCmp[Cmp2Int]{{1, 2}, {1, 3}}
+	doc := struct {
+		Raw  SortedRaw[string]
+		Obj  SortedObj[int64, Cmp2Int]
+		Cmps SortedCmp[Cmp2Int]
+	}{raw, obj, cmps}
+
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if string(encoded) != `{"Raw":["a","b","c"],"Obj":[{"A":1,"B":2},{"A":3,"B":4}],"Cmps":[{"A":1,"B":2},{"A":1,"B":3}]}` {
+		T.Fatal(string(encoded))
+	}
+	doc.Raw, doc.Obj, doc.Cmps = nil, nil, nil
+	if err = json.Unmarshal(encoded, &doc); err != nil {
+		T.Fatal(err)
+	}
+	doc.Raw.Assert()
+	doc.Obj.Assert()
+	doc.Cmps.Assert()
+	if doc.Raw.Len() != 3 || doc.Obj.Len() != 2 || doc.Cmps.Len() != 2 {
+		T.Fatal()
+	}
+}
+
+func TestJSON_Modes(T *testing.T) {
+	var bag SortedRaw[int]
+	if err := bag.UnmarshalJSONMode([]byte(`[3,1,2]`), DecodeSort); err != nil {
+		T.Fatal(err)
+	}
+	bag.Assert()
+
+	var oe *OrderError
+	err := bag.UnmarshalJSONMode([]byte(`[1,3,2]`), DecodeStrict)
+	if !errors.As(err, &oe) || oe.Index != 2 || !errors.Is(err, ErrUnsorted) {
+		T.Fatal(err)
+	}
+	err = bag.UnmarshalJSONMode([]byte(`[1,2,2]`), DecodeStrict)
+	if !errors.As(err, &oe) || oe.Index != 2 || !errors.Is(err, ErrDuplicates) {
+		T.Fatal(err)
+	}
+	if bag.Len() != 3 || bag[0] != 1 || bag[2] != 3 {
+		T.Fatal("bag altered by a failed decoding", bag)
+	}
+
+	if err = bag.UnmarshalJSONMode([]byte(`[2,1]`), DecodeTrust); err != nil {
+		T.Fatal(err)
+	}
+	if bag.Check() != ErrUnsorted {
+		T.Fatal("trusted input kept as is")
+	}
+
+	var cmps SortedCmp[CmpInt]
+	if err = cmps.UnmarshalJSONMode([]byte(`{}`), DecodeSort); err == nil {
+		T.Fatal()
+	}
+	var obj SortedObj[int64, Cmp2Int]
+	if err = json.Unmarshal([]byte(`[{"A":2},{"A":1,"B":1}]`), &obj); err != nil || obj.Check() != nil {
+		T.Fatal("UnmarshalJSON always sorts", err, obj)
+	}
+	if err = obj.UnmarshalJSONMode([]byte(`[{"A":2},{"A":1,"B":1}]`), DecodeStrict); !errors.Is(err, ErrUnsorted) {
+		T.Fatal(err)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultLearnedMaxError is the prediction error tolerated by a zero LearnedRaw
+const DefaultLearnedMaxError = 32
+
+// Number is a constraint that permits any integer or floating-point type.
+type Number interface {
+	Integer | Float
+}
+
+// LearnedRaw implements a sorted array of numbers with a learned index.
+// The index is a piecewise-linear model of the position of the items as a
+// function of their value, fitted so that every prediction is at most
+// maxError positions away from the actual position. A lookup then predicts
+// the position and corrects it with a binary search bounded to the error
+// window, instead of a binary search over the whole array.
+// Each mutation shifts the positions by at most one, so the model remains
+// usable with an error window widened by the number of mutations since it
+// was fitted. The model is fitted again by the mutation that makes that
+// number exceed the maximal error, amortizing the O(N) cost of the fit.
+// The zero LearnedRaw is empty, with a DefaultLearnedMaxError tolerance.
+type LearnedRaw[T Number] struct {
+	items    SortedRaw[T]
+	segments []learnedSegment
+	maxError int
+	// pending counts the items inserted or removed since the last fit
+	pending int
+}
+
+// learnedSegment predicts the positions of the items from key to the key of
+// the next segment.
+type learnedSegment struct {
+	key   float64
+	start int
+	slope float64
+}
+
+// NewLearnedRaw returns a LearnedRaw whose model is fitted with the given maximal error
+func NewLearnedRaw[T Number](maxError int, items ...T) *LearnedRaw[T] {
+	if maxError <= 0 {
+		maxError = DefaultLearnedMaxError
+	}
+	s := &LearnedRaw[T]{maxError: maxError}
+	s.items.Append(items...)
+	s.Rebuild()
+	return s
+}
+
+// Len returns the number of items in the array
+func (s *LearnedRaw[T]) Len() int { return len(s.items) }
+
+// Items returns the underlying sorted array, that must not be modified
+func (s *LearnedRaw[T]) Items() SortedRaw[T] { return s.items }
+
+// Stale tells if the array has been modified since the model was last fitted
+func (s *LearnedRaw[T]) Stale() bool { return s.pending > 0 }
+
+// Add introduces a new item in the sorted array
+func (s *LearnedRaw[T]) Add(a T) {
+	s.items.Add(a)
+	s.touch(1)
+}
+
+// Append introduces several items in the sorted array
+func (s *LearnedRaw[T]) Append(a ...T) {
+	s.items.Append(a...)
+	s.touch(len(a))
+}
+
+// Remove removes the first item with the given value
+func (s *LearnedRaw[T]) Remove(a T) {
+	n := len(s.items)
+	s.items.Remove(a)
+	s.touch(n - len(s.items))
+}
+
+// touch accounts for mutated items and fits the model again once the
+// predictions may be too far from the actual positions.
+func (s *LearnedRaw[T]) touch(n int) {
+	s.pending += n
+	if s.pending > s.tolerance() {
+		s.Rebuild()
+	}
+}
+
+func (s *LearnedRaw[T]) tolerance() int {
+	if s.maxError <= 0 {
+		s.maxError = DefaultLearnedMaxError
+	}
+	return s.maxError
+}
+
+// Rebuild fits the model over the current content of the array, in O(N).
+// The mutations call it when necessary.
+func (s *LearnedRaw[T]) Rebuild() {
+	s.segments = s.segments[:0]
+	s.pending = 0
+	eps := float64(s.tolerance())
+	for i := 0; i < len(s.items); {
+		x0 := float64(s.items[i])
+		lo, hi := 0.0, math.Inf(1)
+		j := i + 1
+		for ; j < len(s.items); j++ {
+			dx := float64(s.items[j]) - x0
+			if dx <= 0 {
+				// Duplicates are all predicted at the position of the first one
+				if float64(j-i) > eps {
+					break
+				}
+				continue
+			}
+			newLo := math.Max(lo, (float64(j-i)-eps)/dx)
+			newHi := math.Min(hi, (float64(j-i)+eps)/dx)
+			if newLo > newHi {
+				break
+			}
+			lo, hi = newLo, newHi
+		}
+		slope := lo
+		if !math.IsInf(hi, 1) {
+			slope = (lo + hi) / 2
+		}
+		s.segments = append(s.segments, learnedSegment{key: x0, start: i, slope: slope})
+		i = j
+	}
+}
+
+// Segments returns the number of linear pieces of the model
+func (s *LearnedRaw[T]) Segments() int { return len(s.segments) }
+
+// search returns the position of the first item for which before returns
+// false. The value id is used to predict the position.
+func (s *LearnedRaw[T]) search(id T, before func(x T) bool) int {
+	n := len(s.items)
+	if len(s.segments) == 0 {
+		return sort.Search(n, func(i int) bool { return !before(s.items[i]) })
+	}
+
+	x := float64(id)
+	seg := sort.Search(len(s.segments), func(i int) bool { return s.segments[i].key > x }) - 1
+	if seg < 0 {
+		seg = 0
+	}
+	predicted := float64(s.segments[seg].start) + s.segments[seg].slope*(x-s.segments[seg].key)
+	if !(predicted >= 0) {
+		predicted = 0
+	} else if predicted > float64(n) {
+		predicted = float64(n)
+	}
+	pred := int(predicted)
+	window := s.maxError + s.pending
+	lo, hi := pred-window-1, pred+window+2
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > n {
+		hi = n
+	}
+	if lo > hi {
+		lo = hi
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool { return !before(s.items[lo+i]) })
+	// The model is only a hint: the boundaries of the window are checked and
+	// a full search is run when the answer lies outside the window.
+	if (i > 0 && !before(s.items[i-1])) || (i < n && before(s.items[i])) {
+		return sort.Search(n, func(i int) bool { return !before(s.items[i]) })
+	}
+	return i
+}
+
+// GetIndex returns -1 if no item of the array is identical to the given value, or the position
+// of the first element.
+func (s *LearnedRaw[T]) GetIndex(id T) int {
+	i := s.search(id, func(x T) bool { return x < id })
+	if i < len(s.items) && s.items[i] == id {
+		return i
+	}
+	return -1
+}
+
+// Get tests for the presence of the raw item in the current set and returns
+// a copy of the entity of it is present.
+func (s *LearnedRaw[T]) Get(id T) (out T, ok bool) {
+	idx := s.GetIndex(id)
+	if idx >= 0 {
+		return s.items[idx], true
+	}
+	return out, false
+}
+
+// Has tests for the presence of the raw item in the current set
+func (s *LearnedRaw[T]) Has(id T) bool { return s.GetIndex(id) >= 0 }
+
+// Slice returns at most max items strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (s *LearnedRaw[T]) Slice(marker T, max uint32) []T {
+	max = boundSliceSize(max)
+	start := s.search(marker, func(x T) bool { return x <= marker })
+	if start >= len(s.items) {
+		return s.items[:0]
+	}
+	remaining := uint32(len(s.items) - start)
+	if remaining > max {
+		remaining = max
+	}
+	return s.items[start : uint32(start)+remaining]
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func checkLearned[N Number](T *testing.T, bag *LearnedRaw[N], needles []N) {
+	ref := bag.Items()
+	for _, v := range needles {
+		if bag.GetIndex(v) != ref.GetIndex(v) {
+			T.Fatal("index", v, bag.GetIndex(v), ref.GetIndex(v))
+		}
+		expected, got := ref.Slice(v, 5), bag.Slice(v, 5)
+		if len(expected) != len(got) {
+			T.Fatal("slice", v)
+		}
+		for i := range expected {
+			if expected[i] != got[i] {
+				T.Fatal("slice", v)
+			}
+		}
+	}
+}
+
+func TestLearned_Uniform(T *testing.T) {
+	var items []int64
+	for i := int64(0); i < 10000; i++ {
+		items = append(items, 3*i+7)
+	}
+	bag := NewLearnedRaw[int64](4, items...)
+	if bag.Segments() != 1 {
+		T.Fatal("segments", bag.Segments())
+	}
+	var needles []int64
+	for v := int64(-10); v < 30100; v += 7 {
+		needles = append(needles, v)
+	}
+	checkLearned(T, bag, needles)
+}
+
+func TestLearned_Random(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	var items, needles []uint32
+	for i := 0; i < 5000; i++ {
+		v := uint32(rng.Intn(1 << 20))
+		if i%1000 < 10 {
+			// Clusters of duplicates
+			v = uint32(i / 1000)
+		}
+		items = append(items, v)
+		needles = append(needles, v, v+1)
+	}
+	bag := NewLearnedRaw[uint32](0, items...)
+	checkLearned(T, bag, needles)
+
+	bag.Remove(items[0])
+	bag.Add(1 << 21)
+	if !bag.Stale() {
+		T.Fatal()
+	}
+	checkLearned(T, bag, needles)
+
+	// Enough mutations fit the model again
+	for i := 0; i < DefaultLearnedMaxError && bag.Stale(); i++ {
+		bag.Add(uint32(rng.Intn(1 << 20)))
+	}
+	if bag.Stale() || bag.Segments() == 0 {
+		T.Fatal()
+	}
+	checkLearned(T, bag, needles)
+}
+
+func TestLearned_Float(T *testing.T) {
+	var bag LearnedRaw[float64]
+	bag.Append(0.5, -1.25, 3, 1e9, 2.75)
+	checkLearned(T, &bag, []float64{-2, -1.25, 0, 0.5, 1, 2.75, 3, 4, 1e9, 1e10})
+	bag.Rebuild()
+	checkLearned(T, &bag, []float64{-2, -1.25, 0, 0.5, 1, 2.75, 3, 4, 1e9, 1e10})
+}
+
+func BenchmarkLearned_Has(b *testing.B) {
+	bag, needles := benchmarkNeedles(1 << 20)
+	learned := NewLearnedRaw[uint64](0, bag...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		learned.Has(needles[i%len(needles)])
+	}
+}
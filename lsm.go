@@ -0,0 +1,488 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultMemtableSize is the default number of records of the memtable triggering a flush
+	DefaultMemtableSize = 4096
+	// DefaultCompactionThreshold is the default number of tables triggering a compaction
+	DefaultCompactionThreshold = 4
+
+	lsmMemtableDir = "memtable"
+	lsmTableExt    = ".sst"
+)
+
+// KeyValue is a live entry of an LSMStore
+type KeyValue[K Ordered, V any] struct {
+	Key   K
+	Value V
+}
+
+// LSMStore implements a key-value store as a log-structured merge tree.
+// The writes go to the memtable, a DurableObj whose log protects the
+// unflushed writes. Once full, the memtable is flushed to an immutable table
+// file, and the tables are merged by a compaction once too numerous. The
+// deletions are recorded as tombstones until a compaction drops them.
+// A write that fails to be logged leaves the store unchanged, and the store
+// must be opened again once the memtable reports ErrLogBroken.
+// An LSMStore is not safe for concurrent use.
+type LSMStore[K Ordered, V any] struct {
+	dir    string
+	codec  lsmCodec[K, V]
+	mem    *DurableObj[K, lsmRecord[K, V]]
+	tables []*lsmTable[K, V] // newest first
+	nextID uint64
+	// maintErr holds the error of the last automatic flush or compaction
+	maintErr error
+
+	// MemtableSize is the number of records of the memtable triggering a flush
+	MemtableSize int
+	// CompactionThreshold is the number of tables triggering the merge of
+	// all of them into a single one. Zero disables the compactions.
+	// A failed automatic flush or compaction is reported by MaintenanceErr,
+	// not by the write that triggered it.
+	CompactionThreshold int
+}
+
+// lsmRecord is a versioned entry of the store, a tombstone if deleted is set
+type lsmRecord[K Ordered, V any] struct {
+	key     K
+	value   V
+	deleted bool
+}
+
+func (r lsmRecord[K, V]) PK() K { return r.key }
+
+type lsmTable[K Ordered, V any] struct {
+	id    uint64
+	base  uint64
+	f     *os.File
+	table *Table[K, lsmRecord[K, V]]
+}
+
+// OpenLSMStore opens the store in the given directory, that is created if
+// necessary. The keys and the values are encoded with the given codecs and
+// the log of the memtable is synced according to the policy.
+func OpenLSMStore[K Ordered, V any](dir string, keys Codec[K], values Codec[V], policy SyncPolicy) (*LSMStore[K, V], error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &LSMStore[K, V]{
+		dir:                 dir,
+		codec:               lsmCodec[K, V]{keys: keys, values: values},
+		MemtableSize:        DefaultMemtableSize,
+		CompactionThreshold: DefaultCompactionThreshold,
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names [][2]uint64
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Left by a crash during a flush or a compaction
+			os.Remove(filepath.Join(dir, name))
+		} else if id, base, ok := parseTableName(name); ok {
+			names = append(names, [2]uint64{id, base})
+		}
+	}
+	// The newest first. A table covered by the range of a newer one is a
+	// leftover of a compaction interrupted by a crash.
+	slices.SortFunc(names, func(a, b [2]uint64) int { return cmp.Compare(b[0], a[0]) })
+	lowest := uint64(math.MaxUint64)
+	for _, n := range names {
+		s.nextID = max(s.nextID, n[0]+1)
+		if n[0] >= lowest {
+			os.Remove(s.tablePath(n[0], n[1]))
+			continue
+		}
+		t, err := s.openTable(n[0], n[1])
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.tables = append(s.tables, t)
+		lowest = n[1]
+	}
+	if s.mem, err = OpenDurableObj[K](filepath.Join(dir, lsmMemtableDir), Codec[lsmRecord[K, V]](s.codec), policy); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Tables returns the number of table files
+func (s *LSMStore[K, V]) Tables() int { return len(s.tables) }
+
+// MaintenanceErr returns the error of the last automatic flush or
+// compaction, or nil if it succeeded. The write that triggered it is durable
+// in the log of the memtable anyway, so it doesn't fail, and the flush is
+// attempted again by the next write.
+func (s *LSMStore[K, V]) MaintenanceErr() error { return s.maintErr }
+
+// Put sets the value of the key
+func (s *LSMStore[K, V]) Put(key K, value V) error {
+	return s.write(lsmRecord[K, V]{key: key, value: value})
+}
+
+// Delete removes the key. Deleting an absent key is not an error.
+func (s *LSMStore[K, V]) Delete(key K) error {
+	return s.write(lsmRecord[K, V]{key: key, deleted: true})
+}
+
+// The memtable keeps every version of a key, the newest last
+func (s *LSMStore[K, V]) write(r lsmRecord[K, V]) error {
+	if err := s.mem.Add(r); err != nil {
+		return err
+	}
+	if s.MemtableSize > 0 && s.mem.Len() >= s.MemtableSize {
+		if s.maintErr = s.flush(); s.maintErr == nil {
+			s.autoCompact()
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the key, looked up in the memtable then in the
+// tables from the newest to the oldest.
+func (s *LSMStore[K, V]) Get(key K) (out V, ok bool, err error) {
+	items := s.mem.Items()
+	if i, _ := slices.BinarySearchFunc(items, key, objUpperBound[K, lsmRecord[K, V]]); i > 0 && items[i-1].key == key {
+		return items[i-1].value, !items[i-1].deleted, nil
+	}
+	for _, t := range s.tables {
+		r, found, err := t.table.Get(key)
+		if err != nil {
+			return out, false, err
+		} else if found {
+			return r.value, !r.deleted, nil
+		}
+	}
+	return out, false, nil
+}
+
+// Has tests for the presence of the key
+func (s *LSMStore[K, V]) Has(key K) (bool, error) {
+	_, ok, err := s.Get(key)
+	return ok, err
+}
+
+// Scan returns at most max entries whose key is strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (s *LSMStore[K, V]) Scan(marker K, max uint32) ([]KeyValue[K, V], error) {
+	out := make([]KeyValue[K, V], 0)
+	max = boundSliceSize(max)
+	before := func(k K) bool { return k <= marker }
+	cursors, err := s.tableCursors(before)
+	if err != nil {
+		return out, err
+	}
+	err = mergeLSM(append([]*lsmCursor[K, V]{s.memCursor(before)}, cursors...), func(r lsmRecord[K, V]) (bool, error) {
+		if !r.deleted {
+			out = append(out, KeyValue[K, V]{Key: r.key, Value: r.value})
+		}
+		return len(out) < int(max), nil
+	})
+	return out, err
+}
+
+// Flush writes the memtable to a new table, then empties it. The tables are
+// then compacted if they are too numerous, and an error of the compaction is
+// reported by MaintenanceErr.
+func (s *LSMStore[K, V]) Flush() error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.autoCompact()
+	return nil
+}
+
+// autoCompact runs the compaction once the tables are too numerous
+func (s *LSMStore[K, V]) autoCompact() {
+	if s.CompactionThreshold > 0 && len(s.tables) >= s.CompactionThreshold {
+		s.maintErr = s.Compact()
+	}
+}
+
+func (s *LSMStore[K, V]) flush() error {
+	if s.mem.Len() == 0 {
+		return nil
+	}
+	// The tombstones are kept to shadow the older tables
+	mem := s.memCursor(func(K) bool { return false })
+	t, err := s.writeTable(s.nextID, func(tw *TableWriter[K, lsmRecord[K, V]]) error {
+		return mergeLSM([]*lsmCursor[K, V]{mem}, func(r lsmRecord[K, V]) (bool, error) { return true, tw.Append(r) })
+	})
+	if err != nil {
+		return err
+	}
+	s.tables = slices.Insert(s.tables, 0, t)
+	// A crash before the reset replays records already flushed: they are
+	// then flushed again to a newer table, that holds the same versions.
+	return s.mem.reset()
+}
+
+// Compact merges all the tables into a single one, keeping the newest
+// version of each key and dropping the tombstones.
+func (s *LSMStore[K, V]) Compact() error {
+	if len(s.tables) < 2 {
+		return nil
+	}
+	cursors, err := s.tableCursors(func(K) bool { return false })
+	if err != nil {
+		return err
+	}
+	// The new table covers the range of the merged ones
+	t, err := s.writeTable(s.tables[len(s.tables)-1].base, func(tw *TableWriter[K, lsmRecord[K, V]]) error {
+		return mergeLSM(cursors, func(r lsmRecord[K, V]) (bool, error) {
+			if r.deleted {
+				return true, nil
+			}
+			return true, tw.Append(r)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	// A crash before the removals leaves obsolete tables, removed by the
+	// next OpenLSMStore.
+	old := s.tables
+	s.tables = []*lsmTable[K, V]{t}
+	var errs []error
+	for _, o := range old {
+		errs = append(errs, o.f.Close(), os.Remove(o.f.Name()))
+	}
+	return errors.Join(errs...)
+}
+
+// Close releases the memtable and the tables. The memtable is not flushed,
+// its log is replayed by the next OpenLSMStore.
+func (s *LSMStore[K, V]) Close() error {
+	var errs []error
+	if s.mem != nil {
+		errs = append(errs, s.mem.Close())
+	}
+	for _, t := range s.tables {
+		errs = append(errs, t.f.Close())
+	}
+	s.mem, s.tables = nil, nil
+	return errors.Join(errs...)
+}
+
+// memCursor returns a cursor on the memtable, positioned at the first key
+// not matching before.
+func (s *LSMStore[K, V]) memCursor(before func(k K) bool) *lsmCursor[K, V] {
+	items := s.mem.Items()
+	pos, _ := slices.BinarySearchFunc(items, 0, func(r lsmRecord[K, V], _ int) int {
+		if before(r.key) {
+			return -1
+		}
+		return 1
+	})
+	c := &lsmCursor[K, V]{items: items, pos: pos, dedup: true}
+	c.skipOlder()
+	return c
+}
+
+// tableCursors returns a cursor per table, from the newest to the oldest,
+// positioned at the first key not matching before.
+func (s *LSMStore[K, V]) tableCursors(before func(k K) bool) ([]*lsmCursor[K, V], error) {
+	out := make([]*lsmCursor[K, V], 0, len(s.tables))
+	for _, t := range s.tables {
+		items, pos, b, err := t.table.seek(before)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, &lsmCursor[K, V]{table: t.table, items: items, pos: pos, block: b})
+	}
+	return out, nil
+}
+
+// A table file is named after its identifier and the identifier of the
+// oldest table it covers, equal for a flushed table and lesser for a
+// compacted one.
+func (s *LSMStore[K, V]) tablePath(id, base uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016x-%016x%s", id, base, lsmTableExt))
+}
+
+func parseTableName(name string) (id, base uint64, ok bool) {
+	name, found := strings.CutSuffix(name, lsmTableExt)
+	left, right, sep := strings.Cut(name, "-")
+	if !found || !sep {
+		return 0, 0, false
+	}
+	id, err := strconv.ParseUint(left, 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	base, err = strconv.ParseUint(right, 16, 64)
+	return id, base, err == nil && base <= id
+}
+
+func (s *LSMStore[K, V]) openTable(id, base uint64) (*lsmTable[K, V], error) {
+	f, err := os.Open(s.tablePath(id, base))
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	table, err := OpenTableObj[K](f, st.Size(), Codec[lsmRecord[K, V]](s.codec))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	return &lsmTable[K, V]{id: id, base: base, f: f, table: table}, nil
+}
+
+// writeTable durably writes a new table covering the tables since base and
+// filled by the hook, then opens it
+func (s *LSMStore[K, V]) writeTable(base uint64, fill func(tw *TableWriter[K, lsmRecord[K, V]]) error) (*lsmTable[K, V], error) {
+	id := s.nextID
+	path := s.tablePath(id, base)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	tw := NewTableWriter(w, Codec[lsmRecord[K, V]](s.codec), lsmRecord[K, V].PK)
+	if err = fill(tw); err != nil {
+		return nil, err
+	}
+	if err = tw.Close(); err != nil {
+		return nil, err
+	}
+	if err = w.Flush(); err != nil {
+		return nil, err
+	}
+	if err = f.Sync(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return nil, err
+	}
+	if err = syncDir(s.dir); err != nil {
+		return nil, err
+	}
+	s.nextID++
+	return s.openTable(id, base)
+}
+
+// lsmCursor walks the records of the memtable, or of a table block by block
+type lsmCursor[K Ordered, V any] struct {
+	items []lsmRecord[K, V]
+	pos   int
+	table *Table[K, lsmRecord[K, V]]
+	block int
+	// dedup skips the older versions of a key, for the memtable
+	dedup bool
+}
+
+func (c *lsmCursor[K, V]) valid() bool { return c.pos < len(c.items) }
+
+func (c *lsmCursor[K, V]) head() lsmRecord[K, V] { return c.items[c.pos] }
+
+func (c *lsmCursor[K, V]) next() error {
+	if c.pos++; c.pos >= len(c.items) && c.table != nil && c.block+1 < len(c.table.firsts) {
+		items, err := c.table.readBlock(c.block + 1)
+		if err != nil {
+			return err
+		}
+		c.items, c.pos, c.block = items, 0, c.block+1
+	}
+	c.skipOlder()
+	return nil
+}
+
+// skipOlder moves to the newest version of the current key
+func (c *lsmCursor[K, V]) skipOlder() {
+	for c.dedup && c.pos+1 < len(c.items) && c.items[c.pos+1].key == c.items[c.pos].key {
+		c.pos++
+	}
+}
+
+// mergeLSM calls the hook on the newest version of each key, in key order,
+// until the hook returns false. The cursors are ordered from the newest to the
+// oldest.
+func mergeLSM[K Ordered, V any](cursors []*lsmCursor[K, V], hook func(r lsmRecord[K, V]) (bool, error)) error {
+	for {
+		var newest *lsmCursor[K, V]
+		for _, c := range cursors {
+			if c.valid() && (newest == nil || c.head().key < newest.head().key) {
+				newest = c
+			}
+		}
+		if newest == nil {
+			return nil
+		}
+		r := newest.head()
+		for _, c := range cursors {
+			if c.valid() && c.head().key == r.key {
+				if err := c.next(); err != nil {
+					return err
+				}
+			}
+		}
+		if more, err := hook(r); err != nil || !more {
+			return err
+		}
+	}
+}
+
+// lsmCodec encodes a record as a flag byte, the key prefixed by its length as
+// an uvarint, then the value unless the record is a tombstone.
+type lsmCodec[K Ordered, V any] struct {
+	keys   Codec[K]
+	values Codec[V]
+}
+
+func (c lsmCodec[K, V]) AppendItem(buf []byte, r lsmRecord[K, V]) []byte {
+	buf = append(buf, byte(b2i(r.deleted)))
+	key := c.keys.AppendItem(nil, r.key)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	if !r.deleted {
+		buf = c.values.AppendItem(buf, r.value)
+	}
+	return buf
+}
+
+func (c lsmCodec[K, V]) DecodeItem(buf []byte) (r lsmRecord[K, V], err error) {
+	if len(buf) < 1 || buf[0] > 1 {
+		return r, fmt.Errorf("%w: bad record flag", ErrFormat)
+	}
+	r.deleted = buf[0] == 1
+	size, n := binary.Uvarint(buf[1:])
+	if n <= 0 || size > uint64(len(buf)-1-n) {
+		return r, fmt.Errorf("%w: bad record key", ErrFormat)
+	}
+	buf = buf[1+n:]
+	if r.key, err = c.keys.DecodeItem(buf[:size]); err != nil || r.deleted {
+		return r, err
+	}
+	r.value, err = c.values.DecodeItem(buf[size:])
+	return r, err
+}
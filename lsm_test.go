@@ -0,0 +1,213 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func openLSM(T *testing.T, dir string) *LSMStore[int, string] {
+	s, err := OpenLSMStore(dir, RawCodec[int](), RawCodec[string](), SyncManual)
+	if err != nil {
+		T.Fatal(err)
+	}
+	s.MemtableSize = 64
+	return s
+}
+
+// checkLSM compares the store with the reference through Get and a paginated Scan
+func checkLSM(T *testing.T, s *LSMStore[int, string], ref map[int]string) {
+	for k := -1; k < 1100; k++ {
+		v, ok, err := s.Get(k)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if expected, present := ref[k]; ok != present || v != expected {
+			T.Fatal(k, v, ok, expected, present)
+		}
+	}
+	var keys []int
+	for k := range ref {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var scanned []int
+	for marker := -1; ; {
+		page, err := s.Scan(marker, 7)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if len(page) > 7 {
+			T.Fatal(len(page))
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, kv := range page {
+			if ref[kv.Key] != kv.Value {
+				T.Fatal(kv)
+			}
+			scanned = append(scanned, kv.Key)
+		}
+		marker = page[len(page)-1].Key
+	}
+	if !slices.Equal(scanned, keys) {
+		T.Fatal(len(scanned), len(keys))
+	}
+}
+
+func TestLSM_Random(T *testing.T) {
+	dir := T.TempDir()
+	s := openLSM(T, dir)
+	s.CompactionThreshold = 3
+	ref := map[int]string{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		k := rng.Intn(1000)
+		if rng.Intn(4) == 0 {
+			delete(ref, k)
+			if err := s.Delete(k); err != nil {
+				T.Fatal(err)
+			}
+		} else {
+			v := strconv.Itoa(i)
+			ref[k] = v
+			if err := s.Put(k, v); err != nil {
+				T.Fatal(err)
+			}
+		}
+		if i%500 == 0 {
+			checkLSM(T, s, ref)
+		}
+	}
+	if s.Tables() == 0 || s.Tables() >= 3 {
+		T.Fatal(s.Tables())
+	}
+	checkLSM(T, s, ref)
+
+	// The unflushed memtable is recovered from its log
+	if err := s.Close(); err != nil {
+		T.Fatal(err)
+	}
+	s = openLSM(T, dir)
+	checkLSM(T, s, ref)
+	if err := s.Flush(); err != nil {
+		T.Fatal(err)
+	}
+	if err := s.Compact(); err != nil {
+		T.Fatal(err)
+	}
+	if s.Tables() != 1 {
+		T.Fatal(s.Tables())
+	}
+	checkLSM(T, s, ref)
+	s.Close()
+}
+
+func TestLSM_InterruptedCompaction(T *testing.T) {
+	dir := T.TempDir()
+	s := openLSM(T, dir)
+	s.CompactionThreshold = 0
+	s.Put(1, "a")
+	s.Put(2, "b")
+	s.Flush()
+	s.Delete(1)
+	s.Flush()
+
+	// Keep a copy of the tables merged by the compaction
+	olds, _ := filepath.Glob(filepath.Join(dir, "*"+lsmTableExt))
+	saved := map[string][]byte{}
+	for _, path := range olds {
+		saved[path], _ = os.ReadFile(path)
+	}
+	if err := s.Compact(); err != nil {
+		T.Fatal(err)
+	}
+	s.Put(3, "c")
+	s.Flush()
+	s.Close()
+	for path, data := range saved {
+		os.WriteFile(path, data, 0o644)
+	}
+
+	// The deleted key must not be resurrected by the leftovers
+	s = openLSM(T, dir)
+	defer s.Close()
+	checkLSM(T, s, map[int]string{2: "b", 3: "c"})
+	if s.Tables() != 2 {
+		T.Fatal(s.Tables())
+	}
+	if tables, _ := filepath.Glob(filepath.Join(dir, "*"+lsmTableExt)); len(tables) != 2 {
+		T.Fatal(tables)
+	}
+}
+
+func TestLSM_FlushFailure(T *testing.T) {
+	dir := T.TempDir()
+	s := openLSM(T, dir)
+	ref := map[int]string{}
+
+	// A non-empty directory prevents the next table from being renamed
+	blocker := s.tablePath(s.nextID, s.nextID)
+	if err := os.MkdirAll(filepath.Join(blocker, "x"), 0o755); err != nil {
+		T.Fatal(err)
+	}
+	for k := 0; k < 2*s.MemtableSize; k++ {
+		if err := s.Put(k, strconv.Itoa(k)); err != nil {
+			T.Fatal("the write succeeded", err)
+		}
+		ref[k] = strconv.Itoa(k)
+	}
+	if s.MaintenanceErr() == nil || s.Tables() != 0 {
+		T.Fatal("flush failure not reported", s.Tables())
+	}
+	checkLSM(T, s, ref)
+
+	os.RemoveAll(blocker)
+	if err := s.Delete(0); err != nil || s.MaintenanceErr() != nil || s.Tables() != 1 {
+		T.Fatal(err, s.MaintenanceErr(), s.Tables())
+	}
+	delete(ref, 0)
+	s.Close()
+	s = openLSM(T, dir)
+	defer s.Close()
+	checkLSM(T, s, ref)
+}
+
+func TestLSM_LogFailure(T *testing.T) {
+	dir := T.TempDir()
+	s := openLSM(T, dir)
+	ref := map[int]string{}
+	for k := 0; k < s.MemtableSize+10; k++ {
+		if err := s.Put(k, strconv.Itoa(k)); err != nil {
+			T.Fatal(err)
+		}
+		ref[k] = strconv.Itoa(k)
+	}
+
+	// The failed write leaves neither the memtable nor its log changed
+	log := &faultyLog{File: s.mem.wal.(*os.File), shortWrite: true}
+	s.mem.wal = log
+	if err := s.Put(1, "lost"); !errors.Is(err, errFaulty) {
+		T.Fatal(err)
+	}
+	if err := s.Delete(2); err != nil {
+		T.Fatal(err)
+	}
+	delete(ref, 2)
+	checkLSM(T, s, ref)
+	s.Close()
+
+	s = openLSM(T, dir)
+	defer s.Close()
+	checkLSM(T, s, ref)
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build linux
+
+package bags
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// MappedRaw implements a read-only sorted array of integers backed by a
+// memory-mapped file of little-endian fixed-width integers, e.g. a file
+// written by a sequence of binary.Write calls. The pages are shared by every
+// process mapping the same file, and only the pages touched by the lookups
+// are loaded.
+// The mapping is a zero-copy view, so MappedRaw requires a little-endian host.
+// A MappedRaw is built with OpenMappedRaw and must be released with Close.
+type MappedRaw[T Integer] struct {
+	data  []byte
+	items SortedRaw[T]
+}
+
+// OpenMappedRaw maps the file at the given path. If validate is true, the
+// whole file is scanned and an *OrderError is returned if the integers are
+// not in ascending order.
+func OpenMappedRaw[T Integer](path string, validate bool) (*MappedRaw[T], error) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		return nil, fmt.Errorf("%w: big-endian host", ErrFormat)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var zero T
+	width := int64(unsafe.Sizeof(zero))
+	size := st.Size()
+	if size%width != 0 {
+		return nil, fmt.Errorf("%w: size %d is not a multiple of %d", ErrFormat, size, width)
+	}
+	m := &MappedRaw[T]{}
+	if size == 0 {
+		return m, nil
+	}
+	if m.data, err = syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED); err != nil {
+		return nil, err
+	}
+	m.items = unsafe.Slice((*T)(unsafe.Pointer(&m.data[0])), size/width)
+
+	if validate {
+		for i := 1; i < len(m.items); i++ {
+			if m.items[i-1] > m.items[i] {
+				m.Close()
+				return nil, &OrderError{Index: i, Err: ErrUnsorted}
+			}
+		}
+	}
+	return m, nil
+}
+
+// Close unmaps the file. The MappedRaw and the slices it returned must not be
+// used afterward.
+func (m *MappedRaw[T]) Close() error {
+	data := m.data
+	m.data, m.items = nil, nil
+	if data == nil {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
+
+// Items returns the mapped array, for the read-only methods of SortedRaw.
+// WARNING: the memory is mapped read-only, and any write in place through
+// the returned array, e.g. by Remove, Update, a sort or an assignment,
+// crashes the process with SIGSEGV instead of panicking. Clone it before
+// modifying it.
+func (m *MappedRaw[T]) Items() SortedRaw[T] { return m.items }
+
+// Len returns the number of items in the array
+func (m *MappedRaw[T]) Len() int { return len(m.items) }
+
+// First returns the smallest item of the array
+func (m *MappedRaw[T]) First() (out T, ok bool) {
+	if len(m.items) == 0 {
+		return out, false
+	}
+	return m.items[0], true
+}
+
+// Last returns the greatest item of the array
+func (m *MappedRaw[T]) Last() (out T, ok bool) {
+	if len(m.items) == 0 {
+		return out, false
+	}
+	return m.items[len(m.items)-1], true
+}
+
+// GetIndex returns -1 if no item of the array is identical to the given value, or the position
+// of the first element.
+func (m *MappedRaw[T]) GetIndex(id T) int { return m.items.GetIndex(id) }
+
+// Get tests for the presence of the raw item in the current set and returns
+// a copy of the entity of it is present.
+func (m *MappedRaw[T]) Get(id T) (T, bool) { return m.items.Get(id) }
+
+// Has tests for the presence of the raw item in the current set
+func (m *MappedRaw[T]) Has(id T) bool { return m.items.Has(id) }
+
+// Slice returns at most max items strictly greater than the marker, as a
+// read-only view of the mapping that must not be written to.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (m *MappedRaw[T]) Slice(marker T, max uint32) []T { return m.items.Slice(marker, max) }
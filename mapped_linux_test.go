@@ -0,0 +1,88 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build linux
+
+package bags
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeIntegers[N Integer](T *testing.T, items ...N) string {
+	path := filepath.Join(T.TempDir(), "ids")
+	f, err := os.Create(path)
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer f.Close()
+	if err = binary.Write(f, binary.LittleEndian, items); err != nil {
+		T.Fatal(err)
+	}
+	return path
+}
+
+func TestMapped_Lookup(T *testing.T) {
+	var ref SortedRaw[uint64]
+	for i := uint64(0); i < 10000; i++ {
+		ref = append(ref, 3*i)
+	}
+	m, err := OpenMappedRaw[uint64](writeIntegers(T, ref...), true)
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer m.Close()
+	if m.Len() != ref.Len() {
+		T.Fatal()
+	}
+	if first, ok := m.First(); !ok || first != 0 {
+		T.Fatal()
+	}
+	if last, ok := m.Last(); !ok || last != 3*9999 {
+		T.Fatal()
+	}
+	for v := uint64(0); v < 30010; v += 7 {
+		if m.GetIndex(v) != ref.GetIndex(v) || m.Has(v) != ref.Has(v) {
+			T.Fatal(v)
+		}
+		if s := m.Slice(v, 3); len(s) > 0 && s[0] != ref.Slice(v, 3)[0] {
+			T.Fatal(v)
+		}
+	}
+	if err = m.Close(); err != nil || m.Len() != 0 {
+		T.Fatal(err)
+	}
+}
+
+func TestMapped_Errors(T *testing.T) {
+	path := writeIntegers[int32](T, 1, 3, 2)
+	if _, err := OpenMappedRaw[int32](path, true); !errors.Is(err, ErrUnsorted) {
+		T.Fatal(err)
+	}
+	if m, err := OpenMappedRaw[int32](path, false); err != nil {
+		T.Fatal(err)
+	} else {
+		m.Close()
+	}
+	if _, err := OpenMappedRaw[int64](path, false); !errors.Is(err, ErrFormat) {
+		T.Fatal(err)
+	}
+	if _, err := OpenMappedRaw[int64](filepath.Join(T.TempDir(), "missing"), false); !os.IsNotExist(err) {
+		T.Fatal(err)
+	}
+
+	m, err := OpenMappedRaw[uint16](writeIntegers[uint16](T), true)
+	if err != nil || m.Len() != 0 || m.Has(0) {
+		T.Fatal(err)
+	}
+	if _, ok := m.First(); ok {
+		T.Fatal()
+	}
+	m.Close()
+}
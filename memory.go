@@ -0,0 +1,158 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"slices"
+	"unsafe"
+)
+
+// CompactRatio is the ratio of the capacity to the length of an array above
+// which Compact reallocates it. Compact then leaves a capacity of half that
+// ratio times the length, so that the array has to lose half its items
+// again before the next reallocation.
+const CompactRatio = 4
+
+// SizeBytes estimates the memory held by the array: its backing array, plus
+// the memory pointed to by each item as reported by the optional sizer.
+func (s SortedRaw[T]) SizeBytes(sizer func(x *T) int) int { return sliceSizeBytes(s, sizer) }
+
+// Grow grows the capacity of the array to guarantee space for n more items
+func (s *SortedRaw[T]) Grow(n int) { *s = slices.Grow(*s, n) }
+
+// Shrink reallocates the array to fit its length if its capacity exceeds
+// threshold times its length, and tells if it did.
+func (s *SortedRaw[T]) Shrink(threshold float64) bool {
+	var ok bool
+	*s, ok = shrinkSlice(*s, threshold)
+	return ok
+}
+
+// Compact releases the excess capacity of the array with some hysteresis, and
+// tells if it did. It is never called implicitly: call it after the removals,
+// or use the bag through its AutoCompact wrapper.
+func (s *SortedRaw[T]) Compact() bool {
+	var ok bool
+	*s, ok = compactSlice(*s)
+	return ok
+}
+
+// SizeBytes estimates the memory held by the array: its backing array, plus
+// the memory pointed to by each item as reported by the optional sizer.
+func (s SortedObj[PkType, T]) SizeBytes(sizer func(x *T) int) int { return sliceSizeBytes(s, sizer) }
+
+// Grow grows the capacity of the array to guarantee space for n more items
+func (s *SortedObj[PkType, T]) Grow(n int) { *s = slices.Grow(*s, n) }
+
+// Shrink reallocates the array to fit its length if its capacity exceeds
+// threshold times its length, and tells if it did.
+func (s *SortedObj[PkType, T]) Shrink(threshold float64) bool {
+	var ok bool
+	*s, ok = shrinkSlice(*s, threshold)
+	return ok
+}
+
+// Compact releases the excess capacity of the array with some hysteresis, and
+// tells if it did. It is never called implicitly: call it after the removals,
+// or use the bag through its AutoCompact wrapper.
+func (s *SortedObj[PkType, T]) Compact() bool {
+	var ok bool
+	*s, ok = compactSlice(*s)
+	return ok
+}
+
+// SizeBytes estimates the memory held by the array: its backing array, plus
+// the memory pointed to by each item as reported by the optional sizer.
+func (s SortedCmp[T]) SizeBytes(sizer func(x *T) int) int { return sliceSizeBytes(s, sizer) }
+
+// Grow grows the capacity of the array to guarantee space for n more items
+func (s *SortedCmp[T]) Grow(n int) { *s = slices.Grow(*s, n) }
+
+// Shrink reallocates the array to fit its length if its capacity exceeds
+// threshold times its length, and tells if it did.
+func (s *SortedCmp[T]) Shrink(threshold float64) bool {
+	var ok bool
+	*s, ok = shrinkSlice(*s, threshold)
+	return ok
+}
+
+// Compact releases the excess capacity of the array with some hysteresis, and
+// tells if it did. It is never called implicitly: call it after the removals,
+// or use the bag through its AutoCompact wrapper.
+func (s *SortedCmp[T]) Compact() bool {
+	var ok bool
+	*s, ok = compactSlice(*s)
+	return ok
+}
+
+// AutoCompactRaw is a SortedRaw whose Remove releases the excess capacity,
+// at an amortized O(1) cost per removal.
+type AutoCompactRaw[T Ordered] struct {
+	SortedRaw[T]
+}
+
+// Remove removes the item then compacts the array
+func (s *AutoCompactRaw[T]) Remove(a T) {
+	s.SortedRaw.Remove(a)
+	s.SortedRaw.Compact()
+}
+
+// AutoCompactObj is a SortedObj whose Remove releases the excess capacity,
+// at an amortized O(1) cost per removal.
+type AutoCompactObj[PkType Ordered, T WithPK[PkType]] struct {
+	SortedObj[PkType, T]
+}
+
+// Remove removes the item then compacts the array
+func (s *AutoCompactObj[PkType, T]) Remove(pk PkType) {
+	s.SortedObj.Remove(pk)
+	s.SortedObj.Compact()
+}
+
+// AutoCompactCmp is a SortedCmp whose Remove releases the excess capacity,
+// at an amortized O(1) cost per removal.
+type AutoCompactCmp[T WithCompare[T]] struct {
+	SortedCmp[T]
+}
+
+// Remove removes the item then compacts the array
+func (s *AutoCompactCmp[T]) Remove(a T) {
+	s.SortedCmp.Remove(a)
+	s.SortedCmp.Compact()
+}
+
+func sliceSizeBytes[T any](s []T, sizer func(x *T) int) int {
+	var zero T
+	total := cap(s) * int(unsafe.Sizeof(zero))
+	if sizer != nil {
+		for i := range s {
+			total += sizer(&s[i])
+		}
+	}
+	return total
+}
+
+// shrinkSlice returns a copy of the slice with no spare capacity, if its
+// capacity exceeds threshold times its length. A threshold below 1 is
+// raised to 1.
+func shrinkSlice[T any](s []T, threshold float64) ([]T, bool) {
+	if threshold < 1 {
+		threshold = 1
+	}
+	if float64(cap(s)) <= threshold*float64(len(s)) {
+		return s, false
+	}
+	return append(make([]T, 0, len(s)), s...), true
+}
+
+// compactSlice returns a copy of the slice with a capacity of CompactRatio/2
+// times its length, if its capacity exceeds CompactRatio times its length.
+func compactSlice[T any](s []T) ([]T, bool) {
+	if cap(s) <= CompactRatio*len(s) {
+		return s, false
+	}
+	return append(make([]T, 0, len(s)*CompactRatio/2), s...), true
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"testing"
+)
+
+func TestMemory_SizeBytes(T *testing.T) {
+	bag := make(SortedRaw[string], 0, 10)
+	bag.Append("a", "bb", "ccc")
+	if sz := bag.SizeBytes(nil); sz != 10*16 {
+		T.Fatal(sz)
+	}
+	if sz := bag.SizeBytes(func(x *string) int { return len(*x) }); sz != 10*16+6 {
+		T.Fatal(sz)
+	}
+
+	obj := SortedObj[int64, *Obj]{&Obj{1}, &Obj{2}}
+	if sz := obj.SizeBytes(func(x **Obj) int { return 8 }); sz != cap(obj)*8+2*8 {
+		T.Fatal(sz)
+	}
+}
+
+func TestMemory_GrowShrink(T *testing.T) {
+	var bag SortedCmp[CmpInt]
+	bag.Grow(100)
+	if cap(bag) < 100 || len(bag) != 0 {
+		T.Fatal()
+	}
+	bag.Append(1, 2, 3)
+	if bag.Shrink(float64(cap(bag))) {
+		T.Fatal("nothing to shrink under that threshold")
+	}
+	if !bag.Shrink(2) || cap(bag) != 3 || bag.Len() != 3 {
+		T.Fatal()
+	}
+	bag.Assert()
+	if bag.Shrink(0) {
+		T.Fatal("already tight")
+	}
+}
+
+func TestMemory_Compact(T *testing.T) {
+	var bag SortedRaw[int]
+	bag.Append(shuffledInts(1000)...)
+	reallocs := 0
+	for i := 0; i < 1000; i++ {
+		bag.Remove(i)
+		if bag.Compact() {
+			reallocs++
+		}
+		if cap(bag) > CompactRatio*bag.Len() && bag.Len() > 0 {
+			T.Fatal(i, cap(bag), bag.Len())
+		}
+	}
+	// Each reallocation halves the capacity
+	if reallocs > 10 {
+		T.Fatal(reallocs)
+	}
+
+	obj := SortedObj[int64, *Obj]{&Obj{1}, &Obj{2}}
+	if obj.Compact() {
+		T.Fatal("nothing to compact")
+	}
+	obj.Remove(1)
+	obj.Remove(2)
+	if !obj.Compact() || cap(obj) != 0 {
+		T.Fatal(cap(obj))
+	}
+}
+
+func TestMemory_AutoCompact(T *testing.T) {
+	var bag AutoCompactRaw[int]
+	bag.Append(shuffledInts(1000)...)
+	for i := 0; i < 1000; i++ {
+		bag.Remove(i)
+		if cap(bag.SortedRaw) > CompactRatio*bag.Len() && bag.Len() > 0 {
+			T.Fatal(i, cap(bag.SortedRaw), bag.Len())
+		}
+		if i == 500 {
+			bag.Assert()
+		}
+	}
+
+	var obj AutoCompactObj[int64, *Obj]
+	obj.Append(&Obj{1}, &Obj{2})
+	obj.Remove(1)
+	obj.Remove(2)
+	if cap(obj.SortedObj) != 0 {
+		T.Fatal(cap(obj.SortedObj))
+	}
+
+	var cmps AutoCompactCmp[CmpInt]
+	for i := 0; i < 100; i++ {
+		cmps.Add(CmpInt(i))
+	}
+	for i := 0; i < 90; i++ {
+		cmps.Remove(CmpInt(i))
+	}
+	if cap(cmps.SortedCmp) > CompactRatio*cmps.Len() {
+		T.Fatal(cap(cmps.SortedCmp), cmps.Len())
+	}
+	cmps.Assert()
+}
@@ -0,0 +1,352 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// EventKind tells which mutation an Event reports
+type EventKind int
+
+const (
+	// EventInserted reports an item inserted at Index
+	EventInserted EventKind = iota
+	// EventRemoved reports the item removed from Index
+	EventRemoved
+	// EventReplaced reports the item at Index replaced by an item that
+	// sorts at the same place
+	EventReplaced
+)
+
+// Event reports a mutation of an observable bag. Applying the events in
+// their order, by position, to a copy of the array keeps the copy identical.
+type Event[T any] struct {
+	Kind EventKind
+	// Index is the position of the item in the array right after the mutation,
+	// or right before it for EventRemoved
+	Index int
+	// Item is the inserted, removed or new item
+	Item T
+	// Old is the replaced item, for EventReplaced
+	Old T
+}
+
+// WatchPolicy tells how a Subscription deals with a full channel
+type WatchPolicy int
+
+const (
+	// WatchBlock blocks the mutations until the subscriber receives the event
+	WatchBlock WatchPolicy = iota
+	// WatchDrop drops the event, counted by Subscription.Dropped
+	WatchDrop
+)
+
+// Subscription delivers the events of an observable bag on a channel
+type Subscription[T any] struct {
+	// C receives the events. It is closed after Cancel, by the next mutation
+	// of the bag, or by the Close of the bag.
+	C <-chan Event[T]
+
+	ch      chan Event[T]
+	policy  WatchPolicy
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// Cancel stops the delivery of the events and unblocks a mutation waiting for
+// the subscriber. It is safe to call it concurrently with the mutations.
+func (s *Subscription[T]) Cancel() { s.once.Do(func() { close(s.done) }) }
+
+// Dropped returns the number of events dropped because the channel was full
+func (s *Subscription[T]) Dropped() uint64 { return s.dropped.Load() }
+
+func (s *Subscription[T]) cancelled() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Subscription[T]) send(ev Event[T]) {
+	if s.cancelled() {
+		return
+	}
+	if s.policy == WatchDrop {
+		select {
+		case s.ch <- ev:
+		case <-s.done:
+		default:
+			s.dropped.Add(1)
+		}
+		return
+	}
+	select {
+	case s.ch <- ev:
+	case <-s.done:
+	}
+}
+
+// observers holds the callbacks and the subscriptions of an observable bag.
+// The callbacks are called synchronously by the mutations.
+type observers[T any] struct {
+	mu        sync.Mutex
+	callbacks map[uint64]func(ev Event[T])
+	subs      []*Subscription[T]
+	lastID    uint64
+}
+
+// OnChange registers a callback called on every event, and returns the
+// function unregistering it.
+func (o *observers[T]) OnChange(hook func(ev Event[T])) (cancel func()) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.callbacks == nil {
+		o.callbacks = make(map[uint64]func(ev Event[T]))
+	}
+	o.lastID++
+	id := o.lastID
+	o.callbacks[id] = hook
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		delete(o.callbacks, id)
+	}
+}
+
+// Watch returns a subscription delivering the events on a channel with the
+// given buffer size, and the policy applied once the buffer is full.
+func (o *observers[T]) Watch(buffer int, policy WatchPolicy) *Subscription[T] {
+	ch := make(chan Event[T], buffer)
+	s := &Subscription[T]{C: ch, ch: ch, policy: policy, done: make(chan struct{})}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.subs = append(o.subs, s)
+	return s
+}
+
+// Close cancels the subscriptions and closes their channels. It must not
+// run concurrently with the mutations.
+func (o *observers[T]) Close() {
+	o.mu.Lock()
+	subs := o.subs
+	o.subs = nil
+	o.mu.Unlock()
+	for _, s := range subs {
+		s.Cancel()
+		close(s.ch)
+	}
+}
+
+func (o *observers[T]) notify(events ...Event[T]) {
+	o.mu.Lock()
+	hooks := make([]func(ev Event[T]), 0, len(o.callbacks))
+	for _, h := range o.callbacks {
+		hooks = append(hooks, h)
+	}
+	subs := slices.Clone(o.subs)
+	o.mu.Unlock()
+
+	for _, ev := range events {
+		for _, h := range hooks {
+			h(ev)
+		}
+		for _, s := range subs {
+			s.send(ev)
+		}
+	}
+
+	// The channels are only closed here, where no send can be in progress
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.subs = slices.DeleteFunc(o.subs, func(s *Subscription[T]) bool {
+		if s.cancelled() {
+			close(s.ch)
+			return true
+		}
+		return false
+	})
+}
+
+// mergeNotify merges the stable-sorted batch into the sorted array, after
+// the items comparing equal, and returns the merged array with the insertion
+// events in increasing order of position.
+func mergeNotify[T any](s, batch []T, compare func(a, b T) int) ([]T, []Event[T]) {
+	if len(batch) == 0 {
+		return s, nil
+	}
+	slices.SortStableFunc(batch, compare)
+	out := make([]T, 0, len(s)+len(batch))
+	events := make([]Event[T], 0, len(batch))
+	for len(batch) > 0 {
+		if len(s) > 0 && compare(s[0], batch[0]) <= 0 {
+			out = append(out, s[0])
+			s = s[1:]
+			continue
+		}
+		events = append(events, Event[T]{Kind: EventInserted, Index: len(out), Item: batch[0]})
+		out = append(out, batch[0])
+		batch = batch[1:]
+	}
+	return append(out, s...), events
+}
+
+// ObservableRaw wraps a SortedRaw whose mutations are reported to observers.
+// The mutations must not run concurrently, and the array must only be
+// modified through the ObservableRaw.
+type ObservableRaw[T Ordered] struct {
+	observers[T]
+	bag *SortedRaw[T]
+}
+
+// Observe returns an observable wrapper of the array
+func (s *SortedRaw[T]) Observe() *ObservableRaw[T] { return &ObservableRaw[T]{bag: s} }
+
+// Items returns the current array. It must not be modified.
+func (o *ObservableRaw[T]) Items() SortedRaw[T] { return *o.bag }
+
+// Add introduces a new item and reports its insertion
+func (o *ObservableRaw[T]) Add(a T) {
+	i, _ := slices.BinarySearchFunc(*o.bag, a, rawUpperBound[T])
+	*o.bag = slices.Insert(*o.bag, i, a)
+	o.notify(Event[T]{Kind: EventInserted, Index: i, Item: a})
+}
+
+// Append introduces several items in a single merge pass, and reports their insertions
+func (o *ObservableRaw[T]) Append(a ...T) {
+	var events []Event[T]
+	*o.bag, events = mergeNotify(*o.bag, slices.Clone(a), cmp.Compare[T])
+	o.notify(events...)
+}
+
+// Remove removes the first item equal to the given one and reports it, or
+// returns false if there is none.
+func (o *ObservableRaw[T]) Remove(a T) bool {
+	idx := o.bag.GetIndex(a)
+	if idx < 0 {
+		return false
+	}
+	old := (*o.bag)[idx]
+	*o.bag = slices.Delete(*o.bag, idx, idx+1)
+	o.notify(Event[T]{Kind: EventRemoved, Index: idx, Item: old})
+	return true
+}
+
+// ObservableCmp wraps a SortedCmp whose mutations are reported to observers.
+// The mutations must not run concurrently, and the array must only be
+// modified through the ObservableCmp.
+type ObservableCmp[T WithCompare[T]] struct {
+	observers[T]
+	bag *SortedCmp[T]
+}
+
+// Observe returns an observable wrapper of the array
+func (s *SortedCmp[T]) Observe() *ObservableCmp[T] { return &ObservableCmp[T]{bag: s} }
+
+// Items returns the current array. It must not be modified.
+func (o *ObservableCmp[T]) Items() SortedCmp[T] { return *o.bag }
+
+// Add introduces a new item and reports its insertion
+func (o *ObservableCmp[T]) Add(a T) {
+	i, _ := slices.BinarySearchFunc(*o.bag, a, cmpUpperBound[T])
+	*o.bag = slices.Insert(*o.bag, i, a)
+	o.notify(Event[T]{Kind: EventInserted, Index: i, Item: a})
+}
+
+// Append introduces several items in a single merge pass, and reports their insertions
+func (o *ObservableCmp[T]) Append(a ...T) {
+	var events []Event[T]
+	*o.bag, events = mergeNotify(*o.bag, slices.Clone(a), cmpCompare[T])
+	o.notify(events...)
+}
+
+// Remove removes the first item that compares equal to the given one and
+// reports it, or returns false if there is none.
+func (o *ObservableCmp[T]) Remove(a T) bool {
+	idx := o.bag.GetIndex(a)
+	if idx < 0 {
+		return false
+	}
+	old := (*o.bag)[idx]
+	*o.bag = slices.Delete(*o.bag, idx, idx+1)
+	o.notify(Event[T]{Kind: EventRemoved, Index: idx, Item: old})
+	return true
+}
+
+// Replace replaces the first item that compares equal to the given one and
+// reports it, or returns false if there is none.
+func (o *ObservableCmp[T]) Replace(a T) bool {
+	idx := o.bag.GetIndex(a)
+	if idx < 0 {
+		return false
+	}
+	old := (*o.bag)[idx]
+	(*o.bag)[idx] = a
+	o.notify(Event[T]{Kind: EventReplaced, Index: idx, Item: a, Old: old})
+	return true
+}
+
+// ObservableObj wraps a SortedObj whose mutations are reported to observers.
+// The mutations must not run concurrently, and the array must only be
+// modified through the ObservableObj.
+type ObservableObj[PkType Ordered, T WithPK[PkType]] struct {
+	observers[T]
+	bag *SortedObj[PkType, T]
+}
+
+// Observe returns an observable wrapper of the array
+func (s *SortedObj[PkType, T]) Observe() *ObservableObj[PkType, T] {
+	return &ObservableObj[PkType, T]{bag: s}
+}
+
+// Items returns the current array. It must not be modified.
+func (o *ObservableObj[PkType, T]) Items() SortedObj[PkType, T] { return *o.bag }
+
+// Add introduces a new item and reports its insertion
+func (o *ObservableObj[PkType, T]) Add(a T) {
+	i, _ := slices.BinarySearchFunc(*o.bag, a.PK(), objUpperBound[PkType, T])
+	*o.bag = slices.Insert(*o.bag, i, a)
+	o.notify(Event[T]{Kind: EventInserted, Index: i, Item: a})
+}
+
+// Append introduces several items in a single merge pass, and reports their insertions
+func (o *ObservableObj[PkType, T]) Append(a ...T) {
+	var events []Event[T]
+	*o.bag, events = mergeNotify(*o.bag, slices.Clone(a), objCompare[PkType, T])
+	o.notify(events...)
+}
+
+// Remove removes the first item with the given PRIMARY KEY and reports it,
+// or returns false if there is none.
+func (o *ObservableObj[PkType, T]) Remove(pk PkType) bool {
+	idx := o.bag.GetIndex(pk)
+	if idx < 0 {
+		return false
+	}
+	old := (*o.bag)[idx]
+	*o.bag = slices.Delete(*o.bag, idx, idx+1)
+	o.notify(Event[T]{Kind: EventRemoved, Index: idx, Item: old})
+	return true
+}
+
+// Replace replaces the first item with the same PRIMARY KEY and reports it,
+// or returns false if there is none.
+func (o *ObservableObj[PkType, T]) Replace(a T) bool {
+	idx := o.bag.GetIndex(a.PK())
+	if idx < 0 {
+		return false
+	}
+	old := (*o.bag)[idx]
+	(*o.bag)[idx] = a
+	o.notify(Event[T]{Kind: EventReplaced, Index: idx, Item: a, Old: old})
+	return true
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+// applyEvent replays an event on a copy of an observed array
+func applyEvent[V any](replica []V, ev Event[V]) []V {
+	switch ev.Kind {
+	case EventInserted:
+		return slices.Insert(replica, ev.Index, ev.Item)
+	case EventRemoved:
+		return slices.Delete(replica, ev.Index, ev.Index+1)
+	default:
+		replica[ev.Index] = ev.Item
+		return replica
+	}
+}
+
+func TestObservable_Replica(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+
+	var raw SortedRaw[int]
+	var cmps SortedCmp[cmpTagged]
+	var objs SortedObj[int64, *Obj]
+	oraw, ocmp, oobj := raw.Observe(), cmps.Observe(), objs.Observe()
+	var rawReplica []int
+	var cmpReplica []cmpTagged
+	var objReplica []*Obj
+	oraw.OnChange(func(ev Event[int]) { rawReplica = applyEvent(rawReplica, ev) })
+	ocmp.OnChange(func(ev Event[cmpTagged]) { cmpReplica = applyEvent(cmpReplica, ev) })
+	oobj.OnChange(func(ev Event[*Obj]) { objReplica = applyEvent(objReplica, ev) })
+
+	for i := 0; i < 1000; i++ {
+		v := rng.Intn(50)
+		switch rng.Intn(4) {
+		case 0:
+			oraw.Add(v)
+			ocmp.Add(cmpTagged{v, i})
+			oobj.Add(&Obj{int64(v)})
+		case 1:
+			w := rng.Intn(50)
+			oraw.Append(v, w, v)
+			ocmp.Append(cmpTagged{v, i}, cmpTagged{w, i}, cmpTagged{v, -i})
+			oobj.Append(&Obj{int64(v)}, &Obj{int64(w)})
+		case 2:
+			if oraw.Remove(v) != ocmp.Remove(cmpTagged{V: v}) {
+				T.Fatal("remove", v)
+			}
+			oobj.Remove(int64(v))
+		default:
+			ocmp.Replace(cmpTagged{v, i})
+			oobj.Replace(&Obj{int64(v)})
+		}
+		if !slices.Equal(rawReplica, raw) || !slices.Equal(cmpReplica, cmps) || !slices.Equal(objReplica, objs) {
+			T.Fatal("replica", i)
+		}
+	}
+	if !slices.IsSorted(raw) {
+		T.Fatal()
+	}
+}
+
+func TestObservable_Unsubscribe(T *testing.T) {
+	var bag SortedRaw[int]
+	o := bag.Observe()
+	count := 0
+	cancel := o.OnChange(func(Event[int]) { count++ })
+	o.Add(1)
+	cancel()
+	o.Add(2)
+	if count != 1 {
+		T.Fatal(count)
+	}
+
+	sub := o.Watch(4, WatchBlock)
+	o.Add(3)
+	sub.Cancel()
+	o.Add(4)
+	var received []int
+	for ev := range sub.C {
+		received = append(received, ev.Item)
+	}
+	if !slices.Equal(received, []int{3}) {
+		T.Fatal(received)
+	}
+}
+
+func TestObservable_Backpressure(T *testing.T) {
+	var bag SortedRaw[int]
+	o := bag.Observe()
+
+	drop := o.Watch(2, WatchDrop)
+	block := o.Watch(0, WatchBlock)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			o.Add(i)
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if ev := <-block.C; ev.Item != i || ev.Kind != EventInserted {
+			T.Fatal(ev)
+		}
+	}
+	<-done
+	if drop.Dropped() != 3 || len(drop.C) != 2 {
+		T.Fatal(drop.Dropped(), len(drop.C))
+	}
+
+	// Cancel unblocks a mutation waiting for the subscriber
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		o.Add(10)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	block.Cancel()
+	<-done
+	if _, ok := <-block.C; ok {
+		T.Fatal("channel not closed")
+	}
+
+	o.Close()
+	if _, ok := <-drop.C; !ok {
+		T.Fatal("buffered events lost")
+	}
+}
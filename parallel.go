@@ -0,0 +1,123 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"cmp"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// ParallelSortCutoff is the smallest batch that AppendParallel sorts with
+// several goroutines. Smaller batches are appended with Append.
+const ParallelSortCutoff = 1 << 16
+
+// AppendParallel introduces several items in the sorted array, like Append,
+// but sorts large batches with GOMAXPROCS goroutines before merging them with
+// the current content of the array.
+func (s *SortedRaw[T]) AppendParallel(a ...T) {
+	if len(a) < ParallelSortCutoff {
+		s.Append(a...)
+		return
+	}
+	*s = parallelAppend(*s, a, cmp.Compare[T], runtime.GOMAXPROCS(0))
+}
+
+// AppendParallel introduces several items in the sorted array, like Append,
+// but sorts large batches with GOMAXPROCS goroutines before merging them with
+// the current content of the array.
+func (s *SortedObj[PkType, T]) AppendParallel(a ...T) {
+	if len(a) < ParallelSortCutoff {
+		s.Append(a...)
+		return
+	}
+	*s = parallelAppend(*s, a, objCompare[PkType, T], runtime.GOMAXPROCS(0))
+}
+
+// AppendParallel introduces several items in the sorted array, like Append,
+// but sorts large batches with GOMAXPROCS goroutines before merging them with
+// the current content of the array.
+func (s *SortedCmp[T]) AppendParallel(a ...T) {
+	if len(a) < ParallelSortCutoff {
+		s.Append(a...)
+		return
+	}
+	*s = parallelAppend(*s, a, cmpCompare[T], runtime.GOMAXPROCS(0))
+}
+
+// parallelAppend appends the batch to the sorted slice, sorts the batch in
+// chunks with the given number of workers, and then merges the chunks and the
+// original slice, pairwise and in parallel. The whole operation is stable.
+func parallelAppend[T any](sorted, batch []T, compare func(a, b T) int, workers int) []T {
+	out := append(sorted, batch...)
+	if workers < 2 {
+		slices.SortStableFunc(out[len(sorted):], compare)
+		workers = 1
+	}
+
+	// The original slice is the first run, followed by one run per worker
+	bounds := []int{0, len(sorted)}
+	for i := 1; i <= workers; i++ {
+		bounds = append(bounds, len(sorted)+i*len(batch)/workers)
+	}
+	if workers > 1 {
+		var wg sync.WaitGroup
+		for i := 1; i < len(bounds)-1; i++ {
+			wg.Add(1)
+			go func(run []T) {
+				defer wg.Done()
+				slices.SortStableFunc(run, compare)
+			}(out[bounds[i]:bounds[i+1]])
+		}
+		wg.Wait()
+	}
+
+	src, dst := out, make([]T, len(out))
+	for len(bounds) > 2 {
+		next := []int{0}
+		var wg sync.WaitGroup
+		for i := 0; i+1 < len(bounds); i += 2 {
+			if i+2 >= len(bounds) {
+				copy(dst[bounds[i]:bounds[i+1]], src[bounds[i]:bounds[i+1]])
+				next = append(next, bounds[i+1])
+				continue
+			}
+			lo, mid, hi := bounds[i], bounds[i+1], bounds[i+2]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mergeRuns(dst[lo:hi], src[lo:mid], src[mid:hi], compare)
+			}()
+			next = append(next, hi)
+		}
+		wg.Wait()
+		src, dst = dst, src
+		bounds = next
+	}
+	if len(out) > 0 && &src[0] != &out[0] {
+		copy(out, src)
+	}
+	return out
+}
+
+// mergeRuns merges the sorted runs a and b into dst, with the items of a first
+// in case of ties.
+func mergeRuns[T any](dst, a, b []T, compare func(a, b T) int) {
+	i, j, k := 0, 0, 0
+	for i < len(a) && j < len(b) {
+		if compare(b[j], a[i]) < 0 {
+			dst[k] = b[j]
+			j++
+		} else {
+			dst[k] = a[i]
+			i++
+		}
+		k++
+	}
+	k += copy(dst[k:], a[i:])
+	copy(dst[k:], b[j:])
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+)
+
+func TestParallel_Append(T *testing.T) {
+	for _, workers := range []int{1, 2, 3, 8} {
+		for _, n := range []int{0, 1, 7, 1000} {
+			var sorted SortedRaw[int]
+			sorted.Append(shuffledInts(n)...)
+			batch := shuffledInts(3 * n)
+			out := SortedRaw[int](parallelAppend(sorted, batch, cmp.Compare[int], workers))
+			if len(out) != 4*n {
+				T.Fatal("workers", workers, "n", n)
+			}
+			if !slices.IsSorted(out) {
+				T.Fatal("workers", workers, "n", n)
+			}
+		}
+	}
+}
+
+func TestParallel_Stable(T *testing.T) {
+	var bag SortedObj[int64, Cmp2Int]
+	for i := 0; i < 10; i++ {
+		bag.Add(Cmp2Int{i, 0})
+	}
+	var batch []Cmp2Int
+	for i := 0; i < 1000; i++ {
+		batch = append(batch, Cmp2Int{9 - i%10, 1 + i/10})
+	}
+	bag = parallelAppend(bag, batch, objCompare[int64, Cmp2Int], 4)
+	for i := 1; i < len(bag); i++ {
+		if bag[i-1].A == bag[i].A && bag[i-1].B >= bag[i].B {
+			T.Fatal("unstable at", i)
+		}
+	}
+}
+
+func TestParallel_Flavors(T *testing.T) {
+	input := shuffledInts(ParallelSortCutoff + 10)
+
+	var raw SortedRaw[int]
+	raw.AppendParallel(input...)
+	raw.Assert()
+
+	var obj SortedObj[int64, *Obj]
+	var objs []*Obj
+	for _, v := range input {
+		objs = append(objs, &Obj{int64(v)})
+	}
+	obj.AppendParallel(objs...)
+	obj.Assert()
+
+	var c SortedCmp[CmpInt]
+	var cmps []CmpInt
+	for _, v := range input {
+		cmps = append(cmps, CmpInt(v))
+	}
+	c.AppendParallel(cmps[:10]...)
+	c.AppendParallel(cmps[10:]...)
+	c.Assert()
+}
+
+func BenchmarkParallel_Append(b *testing.B) {
+	input := shuffledInts(1 << 20)
+	for i := 0; i < b.N; i++ {
+		var bag SortedRaw[int]
+		bag.AppendParallel(input...)
+	}
+}
+
+func BenchmarkParallel_AppendSerial(b *testing.B) {
+	input := shuffledInts(1 << 20)
+	for i := 0; i < b.N; i++ {
+		var bag SortedRaw[int]
+		bag.Append(input...)
+	}
+}
@@ -0,0 +1,437 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"slices"
+	"sort"
+)
+
+// PersistentObj implements an immutable sorted bag of objects providing a
+// PRIMARY KEY, as a path-copying B+tree. Each mutation returns a new version
+// of the bag and leaves the receiver unchanged: only the nodes on the path to
+// the modified leaf are copied, the other nodes are shared between the
+// versions. Keeping many versions, e.g. for point-in-time reads, thus costs
+// O(log N) nodes per mutation.
+// The versions are safe for concurrent reads, and the mutations of a version
+// may run concurrently since none modifies a node.
+// The nil and the zero PersistentObj are empty bags with a DefaultBTreeFanout
+// fan-out.
+type PersistentObj[PkType Ordered, T WithPK[PkType]] struct {
+	root   *pbNode[PkType, T]
+	fanout int
+	size   int
+}
+
+// pbNode is either a leaf (children is nil) or an internal node. A node is
+// never modified once it is reachable from a version.
+type pbNode[PkType Ordered, T WithPK[PkType]] struct {
+	items    []T
+	children []*pbNode[PkType, T]
+	maxKeys  []PkType
+	counts   []int
+}
+
+// NewPersistentObj returns an empty bag whose nodes hold at most fanout entries.
+// The fan-out is raised to MinBTreeFanout if necessary.
+func NewPersistentObj[PkType Ordered, T WithPK[PkType]](fanout int) *PersistentObj[PkType, T] {
+	return &PersistentObj[PkType, T]{fanout: max(fanout, MinBTreeFanout)}
+}
+
+// Len returns the number of items in the version
+func (t *PersistentObj[PkType, T]) Len() int {
+	if t == nil {
+		return 0
+	}
+	return t.size
+}
+
+func (t *PersistentObj[PkType, T]) maxFill() int {
+	if t == nil || t.fanout <= 0 {
+		return DefaultBTreeFanout
+	}
+	return t.fanout
+}
+
+func (t *PersistentObj[PkType, T]) minFill() int { return t.maxFill() / 2 }
+
+func (t *PersistentObj[PkType, T]) rootNode() *pbNode[PkType, T] {
+	if t == nil {
+		return nil
+	}
+	return t.root
+}
+
+// Add returns a new version with the item, placed after the items with the same PRIMARY KEY
+func (t *PersistentObj[PkType, T]) Add(a T) *PersistentObj[PkType, T] {
+	root := t.rootNode()
+	if root == nil {
+		root = &pbNode[PkType, T]{}
+	}
+	root, sibling := t.insert(root, a)
+	if sibling != nil {
+		root = &pbNode[PkType, T]{
+			children: []*pbNode[PkType, T]{root, sibling},
+			maxKeys:  []PkType{root.maxKey(), sibling.maxKey()},
+			counts:   []int{root.count(), sibling.count()},
+		}
+	}
+	return &PersistentObj[PkType, T]{root: root, fanout: t.maxFill(), size: t.Len() + 1}
+}
+
+// Append returns a new version with the items, regardless the presence of other items with the same PRIMARY KEY
+func (t *PersistentObj[PkType, T]) Append(a ...T) *PersistentObj[PkType, T] {
+	out := t
+	if out == nil {
+		out = &PersistentObj[PkType, T]{}
+	}
+	for _, x := range a {
+		out = out.Add(x)
+	}
+	return out
+}
+
+// Remove returns a new version without the first item with the given PRIMARY
+// KEY, or the receiver itself if there is no such item.
+func (t *PersistentObj[PkType, T]) Remove(pk PkType) *PersistentObj[PkType, T] {
+	root, ok := t.remove(t.rootNode(), pk)
+	if !ok {
+		return t
+	}
+	if !root.isLeaf() && len(root.children) == 1 {
+		root = root.children[0]
+	}
+	return &PersistentObj[PkType, T]{root: root, fanout: t.maxFill(), size: t.size - 1}
+}
+
+// Slice returns at most max items whose PRIMARY KEY is strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (t *PersistentObj[PkType, T]) Slice(marker PkType, max uint32) []T {
+	out := make([]T, 0)
+	if root := t.rootNode(); root != nil {
+		root.collect(marker, int(boundSliceSize(max)), &out)
+	}
+	return out
+}
+
+// GetIndex returns -1 if no item of the version has the given PRIMARY KEY, or the rank of the first
+// item with that PRIMARY KEY
+func (t *PersistentObj[PkType, T]) GetIndex(id PkType) int {
+	leaf, pos, rank := t.seek(id)
+	if leaf != nil && leaf.items[pos].PK() == id {
+		return rank
+	}
+	return -1
+}
+
+// Get returns the first item with the given PRIMARY KEY
+func (t *PersistentObj[PkType, T]) Get(id PkType) (out T, ok bool) {
+	leaf, pos, _ := t.seek(id)
+	if leaf != nil && leaf.items[pos].PK() == id {
+		return leaf.items[pos], true
+	}
+	return out, false
+}
+
+// Has tests for the presence of an item in the version, given the primary key of the item
+func (t *PersistentObj[PkType, T]) Has(id PkType) bool { return t.GetIndex(id) >= 0 }
+
+// At returns the item at the given rank in the version, or panics if the rank is out of range
+func (t *PersistentObj[PkType, T]) At(rank int) T {
+	if rank < 0 || rank >= t.Len() {
+		panic("index out of range")
+	}
+	n := t.root
+	for !n.isLeaf() {
+		i := 0
+		for ; rank >= n.counts[i]; i++ {
+			rank -= n.counts[i]
+		}
+		n = n.children[i]
+	}
+	return n.items[rank]
+}
+
+// SearchPK returns the rank of the first item whose PRIMARY KEY is greater or equal to the
+// needle, or -1 if there is none.
+func (t *PersistentObj[PkType, T]) SearchPK(needle PkType) int {
+	leaf, _, rank := t.seek(needle)
+	if leaf != nil {
+		return rank
+	}
+	return -1
+}
+
+// SearchItem returns the rank of the first item matching the monotonic predicate, or -1 if there is none
+func (t *PersistentObj[PkType, T]) SearchItem(predicate func(x *T) bool) int {
+	return t.SearchIndex(func(i int) bool {
+		x := t.At(i)
+		return predicate(&x)
+	})
+}
+
+// SearchIndex returns the first rank matching the monotonic predicate, or -1 if there is none
+func (t *PersistentObj[PkType, T]) SearchIndex(predicate func(i int) bool) int {
+	i := sort.Search(t.Len(), predicate)
+	if i < t.Len() {
+		return i
+	}
+	return -1
+}
+
+// Each calls the hook on every item, in order, until the hook returns false
+func (t *PersistentObj[PkType, T]) Each(hook func(x T) bool) {
+	if root := t.rootNode(); root != nil {
+		root.each(hook)
+	}
+}
+
+// seek returns the position of the first item whose PRIMARY KEY is greater or
+// equal to the target, with its rank in the whole version. The leaf is nil if
+// there is none.
+func (t *PersistentObj[PkType, T]) seek(target PkType) (*pbNode[PkType, T], int, int) {
+	if t.Len() == 0 {
+		return nil, 0, t.Len()
+	}
+	n, rank := t.root, 0
+	for !n.isLeaf() {
+		i, _ := slices.BinarySearch(n.maxKeys, target)
+		if i >= len(n.children) {
+			return nil, 0, t.size
+		}
+		for _, c := range n.counts[:i] {
+			rank += c
+		}
+		n = n.children[i]
+	}
+	pos, _ := slices.BinarySearchFunc(n.items, target, objComparePK[PkType, T])
+	if pos >= len(n.items) {
+		return nil, 0, t.size
+	}
+	return n, pos, rank + pos
+}
+
+// insert returns a copy of n with the item, and the new right sibling of the
+// copy if it had to be split.
+func (t *PersistentObj[PkType, T]) insert(n *pbNode[PkType, T], a T) (*pbNode[PkType, T], *pbNode[PkType, T]) {
+	pk := a.PK()
+	if n.isLeaf() {
+		pos, _ := slices.BinarySearchFunc(n.items, pk, objUpperBound[PkType, T])
+		items := slices.Insert(slices.Clip(n.items), pos, a)
+		if len(items) <= t.maxFill() {
+			return &pbNode[PkType, T]{items: items}, nil
+		}
+		half := len(items) / 2
+		return &pbNode[PkType, T]{items: slices.Clip(items[:half])}, &pbNode[PkType, T]{items: items[half:]}
+	}
+
+	i, _ := slices.BinarySearchFunc(n.maxKeys, pk, rawUpperBound[PkType])
+	if i >= len(n.children) {
+		i = len(n.children) - 1
+	}
+	child, sibling := t.insert(n.children[i], a)
+	c := n.clone()
+	c.children[i] = child
+	c.refresh(i)
+	if sibling != nil {
+		c.children = insertAt(c.children, i+1, sibling)
+		c.maxKeys = insertAt(c.maxKeys, i+1, sibling.maxKey())
+		c.counts = insertAt(c.counts, i+1, sibling.count())
+	}
+	if len(c.children) <= t.maxFill() {
+		return c, nil
+	}
+	half := len(c.children) / 2
+	right := &pbNode[PkType, T]{
+		children: c.children[half:],
+		maxKeys:  c.maxKeys[half:],
+		counts:   c.counts[half:],
+	}
+	c.children = slices.Clip(c.children[:half])
+	c.maxKeys = slices.Clip(c.maxKeys[:half])
+	c.counts = slices.Clip(c.counts[:half])
+	return c, right
+}
+
+// remove returns a copy of n without the first item with the given PRIMARY
+// KEY, with the fill factor of its children restored. It returns false if
+// there is no such item.
+func (t *PersistentObj[PkType, T]) remove(n *pbNode[PkType, T], pk PkType) (*pbNode[PkType, T], bool) {
+	if n == nil {
+		return nil, false
+	}
+	if n.isLeaf() {
+		pos, _ := slices.BinarySearchFunc(n.items, pk, objComparePK[PkType, T])
+		if pos >= len(n.items) || n.items[pos].PK() != pk {
+			return n, false
+		}
+		return &pbNode[PkType, T]{items: slices.Delete(slices.Clone(n.items), pos, pos+1)}, true
+	}
+
+	i, _ := slices.BinarySearch(n.maxKeys, pk)
+	if i >= len(n.children) {
+		return n, false
+	}
+	child, ok := t.remove(n.children[i], pk)
+	if !ok {
+		return n, false
+	}
+	c := n.clone()
+	c.children[i] = child
+	c.counts[i]--
+	if c.counts[i] > 0 {
+		c.maxKeys[i] = child.maxKey()
+	}
+	if child.fill() < t.minFill() && len(c.children) > 1 {
+		t.rebalance(c, i)
+	}
+	return c, true
+}
+
+// rebalance restores the fill factor of the i-th child of the copy n, either
+// by moving one entry from a sibling or by merging the child with a sibling.
+// The siblings are copied before being modified.
+func (t *PersistentObj[PkType, T]) rebalance(n *pbNode[PkType, T], i int) {
+	if i > 0 && n.children[i-1].fill() > t.minFill() {
+		left, child := n.children[i-1].clone(), n.children[i].clone()
+		if child.isLeaf() {
+			last := len(left.items) - 1
+			child.items = insertAt(child.items, 0, left.items[last])
+			left.items = removeAt(left.items, last)
+		} else {
+			last := len(left.children) - 1
+			child.children = insertAt(child.children, 0, left.children[last])
+			child.maxKeys = insertAt(child.maxKeys, 0, left.maxKeys[last])
+			child.counts = insertAt(child.counts, 0, left.counts[last])
+			left.children = removeAt(left.children, last)
+			left.maxKeys = removeAt(left.maxKeys, last)
+			left.counts = removeAt(left.counts, last)
+		}
+		n.children[i-1], n.children[i] = left, child
+		n.refresh(i - 1)
+		n.refresh(i)
+		return
+	}
+	if i+1 < len(n.children) && n.children[i+1].fill() > t.minFill() {
+		child, right := n.children[i].clone(), n.children[i+1].clone()
+		if child.isLeaf() {
+			child.items = append(child.items, right.items[0])
+			right.items = removeAt(right.items, 0)
+		} else {
+			child.children = append(child.children, right.children[0])
+			child.maxKeys = append(child.maxKeys, right.maxKeys[0])
+			child.counts = append(child.counts, right.counts[0])
+			right.children = removeAt(right.children, 0)
+			right.maxKeys = removeAt(right.maxKeys, 0)
+			right.counts = removeAt(right.counts, 0)
+		}
+		n.children[i], n.children[i+1] = child, right
+		n.refresh(i)
+		n.refresh(i + 1)
+		return
+	}
+
+	// No sibling can spare an entry, merge the child with its right sibling,
+	// or with its left sibling for the last child.
+	if i+1 >= len(n.children) {
+		i--
+	}
+	left, right := n.children[i].clone(), n.children[i+1]
+	if left.isLeaf() {
+		left.items = append(left.items, right.items...)
+	} else {
+		left.children = append(left.children, right.children...)
+		left.maxKeys = append(left.maxKeys, right.maxKeys...)
+		left.counts = append(left.counts, right.counts...)
+	}
+	n.children[i] = left
+	n.children = removeAt(n.children, i+1)
+	n.maxKeys = removeAt(n.maxKeys, i+1)
+	n.counts = removeAt(n.counts, i+1)
+	n.refresh(i)
+}
+
+// clone returns a shallow copy of the node whose slices may be modified
+func (n *pbNode[PkType, T]) clone() *pbNode[PkType, T] {
+	if n.isLeaf() {
+		return &pbNode[PkType, T]{items: slices.Clone(n.items)}
+	}
+	return &pbNode[PkType, T]{
+		children: slices.Clone(n.children),
+		maxKeys:  slices.Clone(n.maxKeys),
+		counts:   slices.Clone(n.counts),
+	}
+}
+
+// collect appends to out the items whose PRIMARY KEY is strictly greater than
+// the marker, until out holds max items.
+func (n *pbNode[PkType, T]) collect(marker PkType, max int, out *[]T) {
+	if n.isLeaf() {
+		pos, _ := slices.BinarySearchFunc(n.items, marker, objUpperBound[PkType, T])
+		end := min(len(n.items), pos+max-len(*out))
+		*out = append(*out, n.items[pos:end]...)
+		return
+	}
+	i, _ := slices.BinarySearchFunc(n.maxKeys, marker, rawUpperBound[PkType])
+	for ; i < len(n.children) && len(*out) < max; i++ {
+		n.children[i].collect(marker, max, out)
+	}
+}
+
+// each calls the hook on every item under the node, and returns false as soon
+// as the hook does.
+func (n *pbNode[PkType, T]) each(hook func(x T) bool) bool {
+	if n.isLeaf() {
+		for _, x := range n.items {
+			if !hook(x) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, c := range n.children {
+		if !c.each(hook) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *pbNode[PkType, T]) isLeaf() bool { return n.children == nil }
+
+// fill returns the number of entries in the node
+func (n *pbNode[PkType, T]) fill() int {
+	if n.isLeaf() {
+		return len(n.items)
+	}
+	return len(n.children)
+}
+
+// count returns the number of items under the node
+func (n *pbNode[PkType, T]) count() int {
+	if n.isLeaf() {
+		return len(n.items)
+	}
+	total := 0
+	for _, c := range n.counts {
+		total += c
+	}
+	return total
+}
+
+// maxKey returns the greatest PRIMARY KEY under a non-empty node
+func (n *pbNode[PkType, T]) maxKey() PkType {
+	if n.isLeaf() {
+		return n.items[len(n.items)-1].PK()
+	}
+	return n.maxKeys[len(n.maxKeys)-1]
+}
+
+// refresh recomputes the summary of the i-th child
+func (n *pbNode[PkType, T]) refresh(i int) {
+	n.maxKeys[i] = n.children[i].maxKey()
+	n.counts[i] = n.children[i].count()
+}
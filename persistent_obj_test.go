@@ -0,0 +1,174 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"errors"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestPersistent_Slice(T *testing.T) {
+	var bag *PersistentObj[int64, *Obj]
+	for i := int64(0); i < 3*MaxSliceSize; i++ {
+		bag = bag.Add(&Obj{i})
+	}
+	testSlice := func(marker int64, max uint32, first int64, count int) {
+		slice := bag.Slice(marker, max)
+		if len(slice) != count {
+			T.Fatal("marker", marker, "max", max, "len", len(slice))
+		}
+		for i, v := range slice {
+			if v.PK() != first+int64(i) {
+				T.Fatal()
+			}
+		}
+	}
+	testSlice(0, 2, 1, 2)
+	testSlice(-1, 2, 0, 2)
+	testSlice(3*MaxSliceSize-1, 1, 0, 0)
+	testSlice(-1, MinSliceSize-1, 0, MinSliceSize)
+	testSlice(-1, MaxSliceSize+1, 0, MaxSliceSize)
+	testSlice(2*MaxSliceSize+10, MaxSliceSize, 2*MaxSliceSize+11, MaxSliceSize-11)
+
+	var empty *PersistentObj[int64, *Obj]
+	if empty.Len() != 0 || empty.Has(0) || len(empty.Slice(-1, 10)) != 0 || empty.Remove(0) != nil {
+		T.Fatal()
+	}
+}
+
+func TestPersistent_Versions(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	for _, fanout := range []int{0, 4, 5, 16} {
+		bag := NewPersistentObj[int64, *Obj](fanout)
+		var ref SortedObj[int64, *Obj]
+		versions := []*PersistentObj[int64, *Obj]{bag}
+		refs := []SortedObj[int64, *Obj]{nil}
+		for i := 0; i < 3000; i++ {
+			v := rng.Int63n(300)
+			if rng.Intn(3) == 0 {
+				bag = bag.Remove(v)
+				ref.Remove(v)
+			} else {
+				x := &Obj{v}
+				bag = bag.Add(x)
+				ref.Add(x)
+			}
+			if bag.Len() != ref.Len() || bag.GetIndex(v) != ref.GetIndex(v) {
+				T.Fatal("len", bag.Len(), ref.Len(), "index", v)
+			}
+			if i%100 == 0 {
+				versions = append(versions, bag)
+				refs = append(refs, slices.Clone(ref))
+			}
+		}
+
+		// The older versions are left unchanged by the later mutations
+		for i, version := range versions {
+			if err := version.check(); err != nil {
+				T.Fatal(fanout, i, err)
+			}
+			var items SortedObj[int64, *Obj]
+			version.Each(func(x *Obj) bool { items = append(items, x); return true })
+			if !slices.Equal(items, refs[i]) {
+				T.Fatal(fanout, "version", i)
+			}
+			for rank, x := range refs[i] {
+				if version.At(rank) != x {
+					T.Fatal(fanout, "rank", rank)
+				}
+			}
+			for v := int64(-1); v < 301; v += 7 {
+				if version.SearchPK(v) != refs[i].SearchPK(v) && !(version.SearchPK(v) < 0 && refs[i].SearchPK(v) == refs[i].Len()) {
+					T.Fatal(fanout, "search", v)
+				}
+				if !slices.Equal(version.Slice(v, 13), refs[i].Slice(v, 13)) {
+					T.Fatal(fanout, "slice", v)
+				}
+			}
+		}
+	}
+}
+
+func TestPersistent_Sharing(T *testing.T) {
+	var bag *PersistentObj[int64, *Obj]
+	for i := int64(0); i < 10000; i++ {
+		bag = bag.Add(&Obj{i})
+	}
+	next := bag.Add(&Obj{5000})
+	shared := 0
+	for i, c := range next.root.children {
+		if c == bag.root.children[i] {
+			shared++
+		}
+	}
+	if shared != len(bag.root.children)-1 {
+		T.Fatal(shared, len(bag.root.children))
+	}
+}
+
+// check validates the ordering of the items and the summaries of the internal nodes
+func (t *PersistentObj[PkType, T]) check() error {
+	if t.rootNode() == nil {
+		if t.Len() != 0 {
+			return errors.New("size of an empty version")
+		}
+		return nil
+	}
+	if t.root.count() != t.size {
+		return errors.New("size mismatch")
+	}
+	var last *PkType
+	var walk func(n *pbNode[PkType, T]) error
+	walk = func(n *pbNode[PkType, T]) error {
+		if n.isLeaf() {
+			for _, x := range n.items {
+				pk := x.PK()
+				if last != nil && pk < *last {
+					return errors.New("unsorted items")
+				}
+				last = &pk
+			}
+			return nil
+		}
+		for i, c := range n.children {
+			if c.count() != n.counts[i] {
+				return errors.New("count mismatch")
+			}
+			if c.count() > 0 && c.maxKey() != n.maxKeys[i] {
+				return errors.New("max key mismatch")
+			}
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(t.root)
+}
+
+func TestPersistent_Search(T *testing.T) {
+	var bag *PersistentObj[int64, *Obj]
+	if bag.SearchIndex(func(int) bool { return true }) != -1 {
+		T.Fatal()
+	}
+	for i := int64(0); i < 1000; i++ {
+		bag = bag.Add(&Obj{2 * i})
+	}
+	for _, pk := range []int64{-1, 0, 1, 999, 1998} {
+		expected := bag.SearchPK(pk)
+		if i := bag.SearchItem(func(x **Obj) bool { return (*x).PK() >= pk }); i != expected {
+			T.Fatal(pk, i, expected)
+		}
+		if i := bag.SearchIndex(func(i int) bool { return bag.At(i).PK() >= pk }); i != expected {
+			T.Fatal(pk, i, expected)
+		}
+	}
+	if bag.SearchItem(func(x **Obj) bool { return (*x).PK() > 1998 }) != -1 {
+		T.Fatal()
+	}
+}
@@ -0,0 +1,477 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+)
+
+const (
+	// DefaultReplicationLog is the default number of changes kept by a
+	// Primary to catch up with the replicas that reconnect
+	DefaultReplicationLog = 4096
+
+	// maxReplicationFrame bounds the size of a frame, to fail on a corrupted
+	// length rather than on a huge allocation.
+	maxReplicationFrame = 64 << 20
+
+	replHello    = 'h'
+	replSnapshot = 's'
+	replEvent    = 'e'
+)
+
+// ErrClosed reports the use of a closed Primary
+var ErrClosed = errors.New("closed")
+
+// The replication stream is a sequence of frames framed like the records of
+// the write-ahead log of DurableObj: the length and the CRC32 (IEEE) of the
+// payload as little-endian uint32, then the payload.
+// The replica sends a hello frame, i.e. the replHello opcode, the sequence
+// number of its last applied change as an uvarint and a byte set to 1 if its
+// array is valid, to bootstrap and to request a resynchronization.
+// The primary sends
+//   - a snapshot: a frame with the replSnapshot opcode, the sequence number
+//     of the last change it covers and the number of items as uvarints,
+//     followed by one frame per encoded item;
+//   - the changes: a frame with the replEvent opcode, the sequence number as
+//     an uvarint, the EventKind as a byte, the index as an uvarint, then the
+//     encoded item.
+
+// Primary holds the authoritative SortedObj and streams its changes to the
+// replicas. The mutations go through the Primary, that assigns them
+// consecutive sequence numbers and keeps the last ones to catch up with the
+// replicas that reconnect.
+// A Primary is safe for concurrent use.
+type Primary[PkType Ordered, T WithPK[PkType]] struct {
+	mu      sync.Mutex
+	obs     *ObservableObj[PkType, T]
+	codec   Codec[T]
+	seq     uint64
+	log     [][]byte
+	logSize int
+	changed chan struct{}
+	done    chan struct{}
+}
+
+// NewPrimary returns a Primary replicating the array, keeping the last
+// logSize changes, or DefaultReplicationLog if logSize is not positive.
+// The array must then only be modified through the Primary.
+func NewPrimary[PkType Ordered, T WithPK[PkType]](bag *SortedObj[PkType, T], codec Codec[T], logSize int) *Primary[PkType, T] {
+	if logSize <= 0 {
+		logSize = DefaultReplicationLog
+	}
+	p := &Primary[PkType, T]{
+		obs:     bag.Observe(),
+		codec:   codec,
+		logSize: logSize,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	// Called by the mutations, under the lock
+	p.obs.OnChange(func(ev Event[T]) {
+		p.seq++
+		payload := binary.AppendUvarint([]byte{replEvent}, p.seq)
+		payload = append(payload, byte(ev.Kind))
+		payload = binary.AppendUvarint(payload, uint64(ev.Index))
+		p.log = append(p.log, p.codec.AppendItem(payload, ev.Item))
+		if len(p.log) > p.logSize {
+			clear(p.log[:len(p.log)-p.logSize])
+			p.log = slices.Clip(p.log[len(p.log)-p.logSize:])
+		}
+	})
+	return p
+}
+
+// Seq returns the sequence number of the last change
+func (p *Primary[PkType, T]) Seq() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seq
+}
+
+// Add introduces a new item, after the items with the same PRIMARY KEY
+func (p *Primary[PkType, T]) Add(a T) { p.mutate(func() { p.obs.Add(a) }) }
+
+// Append introduces several items, regardless the presence of other items with the same PRIMARY KEY
+func (p *Primary[PkType, T]) Append(a ...T) { p.mutate(func() { p.obs.Append(a...) }) }
+
+// Remove removes the first item with the given PRIMARY KEY, and returns false if there is none
+func (p *Primary[PkType, T]) Remove(pk PkType) (ok bool) {
+	p.mutate(func() { ok = p.obs.Remove(pk) })
+	return ok
+}
+
+// Replace replaces the first item with the same PRIMARY KEY, and returns false if there is none
+func (p *Primary[PkType, T]) Replace(a T) (ok bool) {
+	p.mutate(func() { ok = p.obs.Replace(a) })
+	return ok
+}
+
+// Get returns the first item with the given PRIMARY KEY
+func (p *Primary[PkType, T]) Get(id PkType) (T, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.obs.Items().Get(id)
+}
+
+func (p *Primary[PkType, T]) mutate(op func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seq := p.seq
+	op()
+	if p.seq != seq {
+		close(p.changed)
+		p.changed = make(chan struct{})
+	}
+}
+
+// Close stops the Serve loops
+func (p *Primary[PkType, T]) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+}
+
+// Serve streams the changes to the replica connected through rw, until the
+// connection fails or the Primary is closed. A replica whose last change is
+// still in the log catches up from there, otherwise it receives a snapshot.
+// The caller is responsible for closing the connection.
+func (p *Primary[PkType, T]) Serve(rw io.ReadWriter) error {
+	type hello struct {
+		seq   uint64
+		valid bool
+	}
+	requests := make(chan hello)
+	failure := make(chan error, 1)
+	// stop releases the reader when Serve returns before the Primary is closed
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(requests)
+		r := bufio.NewReader(rw)
+		var buf []byte
+		for {
+			payload, err := readFrame(r, &buf)
+			if err != nil {
+				failure <- err
+				return
+			}
+			if len(payload) < 3 || payload[0] != replHello {
+				failure <- fmt.Errorf("%w: bad hello", ErrFormat)
+				return
+			}
+			seq, n := binary.Uvarint(payload[1:])
+			if n <= 0 || 1+n >= len(payload) {
+				failure <- fmt.Errorf("%w: bad hello", ErrFormat)
+				return
+			}
+			select {
+			case requests <- hello{seq: seq, valid: payload[1+n] == 1}:
+			case <-p.done:
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	w := bufio.NewWriter(rw)
+	var next uint64 // the next change to send, 0 until the first hello
+	snapshot := false
+	for {
+		p.mu.Lock()
+		changed := p.changed
+		oldest := p.seq + 1 - uint64(len(p.log))
+		var frames [][]byte
+		if next > 0 && next < oldest {
+			// The replica is too late to catch up from the log
+			snapshot = true
+		}
+		if snapshot {
+			frames, next, snapshot = p.snapshot(), p.seq+1, false
+		} else if next > 0 {
+			frames = slices.Clone(p.log[next-oldest:])
+			next = p.seq + 1
+		}
+		p.mu.Unlock()
+
+		var frame []byte
+		for _, payload := range frames {
+			frame = appendWALRecord(frame[:0], payload)
+			if _, err := w.Write(frame); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-p.done:
+			return ErrClosed
+		case h, ok := <-requests:
+			if !ok {
+				select {
+				case err := <-failure:
+					return err
+				default:
+					return ErrClosed
+				}
+			}
+			p.mu.Lock()
+			oldest = p.seq + 1 - uint64(len(p.log))
+			if h.valid && h.seq+1 >= oldest && h.seq <= p.seq {
+				next = h.seq + 1
+			} else {
+				snapshot = true
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// snapshot returns the frames of a snapshot of the array, under the lock
+func (p *Primary[PkType, T]) snapshot() [][]byte {
+	items := p.obs.Items()
+	header := binary.AppendUvarint([]byte{replSnapshot}, p.seq)
+	frames := [][]byte{binary.AppendUvarint(header, uint64(len(items)))}
+	for _, x := range items {
+		frames = append(frames, p.codec.AppendItem(nil, x))
+	}
+	return frames
+}
+
+// Replica holds a read-only copy of the array of a Primary.
+// A Replica is safe for concurrent use.
+type Replica[PkType Ordered, T WithPK[PkType]] struct {
+	mu        sync.RWMutex
+	bag       SortedObj[PkType, T]
+	codec     Codec[T]
+	seq       uint64
+	valid     bool
+	resyncs   int
+	snapshots int
+}
+
+// NewReplica returns an empty replica
+func NewReplica[PkType Ordered, T WithPK[PkType]](codec Codec[T]) *Replica[PkType, T] {
+	return &Replica[PkType, T]{codec: codec}
+}
+
+// Seq returns the sequence number of the last change applied
+func (r *Replica[PkType, T]) Seq() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.seq
+}
+
+// Resyncs returns the number of resynchronizations requested after a gap in
+// the sequence numbers or a change inconsistent with the local copy.
+func (r *Replica[PkType, T]) Resyncs() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.resyncs
+}
+
+// Snapshots returns the number of snapshots loaded
+func (r *Replica[PkType, T]) Snapshots() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.snapshots
+}
+
+// Items returns a copy of the array
+func (r *Replica[PkType, T]) Items() SortedObj[PkType, T] {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.bag)
+}
+
+// Len returns the number of items in the array
+func (r *Replica[PkType, T]) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.bag.Len()
+}
+
+// Get returns the first item with the given PRIMARY KEY
+func (r *Replica[PkType, T]) Get(id PkType) (T, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.bag.Get(id)
+}
+
+// Has tests for the presence of an item with the given PRIMARY KEY
+func (r *Replica[PkType, T]) Has(id PkType) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.bag.Has(id)
+}
+
+// Slice returns a copy of at most max items whose PRIMARY KEY is strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (r *Replica[PkType, T]) Slice(marker PkType, max uint32) []T {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.bag.Slice(marker, max))
+}
+
+// Run applies the changes received from the Primary connected through rw,
+// until the connection fails. The replica announces its last change so that
+// a Run on a new connection resumes the replication where it stopped.
+func (r *Replica[PkType, T]) Run(rw io.ReadWriter) error {
+	r.mu.RLock()
+	seq, valid := r.seq, r.valid
+	r.mu.RUnlock()
+	if err := r.hello(rw, seq, valid); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(rw)
+	var buf []byte
+	for {
+		payload, err := readFrame(br, &buf)
+		if err != nil {
+			return err
+		}
+		if len(payload) == 0 {
+			return fmt.Errorf("%w: empty frame", ErrFormat)
+		}
+		switch payload[0] {
+		case replSnapshot:
+			err = r.loadSnapshot(br, payload[1:])
+		case replEvent:
+			var resync bool
+			if resync, err = r.apply(payload[1:]); err == nil && resync {
+				err = r.hello(rw, 0, false)
+			}
+		default:
+			err = fmt.Errorf("%w: opcode %q", ErrFormat, payload[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Replica[PkType, T]) hello(w io.Writer, seq uint64, valid bool) error {
+	payload := binary.AppendUvarint([]byte{replHello}, seq)
+	payload = append(payload, byte(b2i(valid)))
+	_, err := w.Write(appendWALRecord(nil, payload))
+	return err
+}
+
+func (r *Replica[PkType, T]) loadSnapshot(br *bufio.Reader, header []byte) error {
+	seq, n := binary.Uvarint(header)
+	count, m := binary.Uvarint(header[max(n, 0):])
+	if n <= 0 || m <= 0 {
+		return fmt.Errorf("%w: bad snapshot header", ErrFormat)
+	}
+	items := make(SortedObj[PkType, T], 0, min(count, 1<<16))
+	var buf []byte
+	for i := uint64(0); i < count; i++ {
+		payload, err := readFrame(br, &buf)
+		if err != nil {
+			return unexpected(err)
+		}
+		x, err := r.codec.DecodeItem(payload)
+		if err != nil {
+			return err
+		}
+		items = append(items, x)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bag, r.seq, r.valid = items, seq, true
+	r.snapshots++
+	return nil
+}
+
+// apply applies a change, and returns true if a resynchronization is needed
+func (r *Replica[PkType, T]) apply(payload []byte) (bool, error) {
+	seq, n := binary.Uvarint(payload)
+	if n <= 0 || n >= len(payload) {
+		return false, fmt.Errorf("%w: bad change", ErrFormat)
+	}
+	kind := EventKind(payload[n])
+	index, m := binary.Uvarint(payload[n+1:])
+	if m <= 0 {
+		return false, fmt.Errorf("%w: bad change", ErrFormat)
+	}
+	x, err := r.codec.DecodeItem(payload[n+1+m:])
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.valid || seq <= r.seq {
+		// Waiting for a snapshot, or already applied
+		return false, nil
+	}
+	idx := int(min(index, uint64(len(r.bag)+1)))
+	consistent := seq == r.seq+1
+	switch kind {
+	case EventInserted:
+		consistent = consistent && idx <= len(r.bag)
+	case EventRemoved, EventReplaced:
+		consistent = consistent && idx < len(r.bag) && r.bag[idx].PK() == x.PK()
+	default:
+		return false, fmt.Errorf("%w: bad change kind %d", ErrFormat, kind)
+	}
+	if !consistent {
+		r.valid = false
+		r.resyncs++
+		return true, nil
+	}
+	switch kind {
+	case EventInserted:
+		r.bag = slices.Insert(r.bag, idx, x)
+	case EventRemoved:
+		r.bag = slices.Delete(r.bag, idx, idx+1)
+	default:
+		r.bag[idx] = x
+	}
+	r.seq = seq
+	return false, nil
+}
+
+// readFrame reads a frame into buf and returns its payload, or io.EOF at the
+// clean end of the stream.
+func readFrame(r io.Reader, buf *[]byte) ([]byte, error) {
+	var header [walHeaderSz]byte
+	// io.ReadFull only returns io.EOF if no byte was read
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[:])
+	if size > maxReplicationFrame {
+		return nil, fmt.Errorf("%w: frame too large", ErrFormat)
+	}
+	if uint32(cap(*buf)) < size {
+		*buf = make([]byte, size)
+	}
+	payload := (*buf)[:size]
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, unexpected(err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, ErrChecksum
+	}
+	return payload, nil
+}
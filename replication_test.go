@@ -0,0 +1,175 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// connect runs a replication session over net.Pipe and returns the function
+// closing it and waiting for both sides.
+func connect(p *Primary[int64, Cmp2Int], r *Replica[int64, Cmp2Int]) (stop func()) {
+	a, b := net.Pipe()
+	done := make(chan struct{}, 2)
+	go func() { p.Serve(a); done <- struct{}{} }()
+	go func() { r.Run(b); done <- struct{}{} }()
+	return func() {
+		a.Close()
+		b.Close()
+		<-done
+		<-done
+	}
+}
+
+func waitReplica(T *testing.T, p *Primary[int64, Cmp2Int], r *Replica[int64, Cmp2Int], bag *SortedObj[int64, Cmp2Int]) {
+	deadline := time.Now().Add(5 * time.Second)
+	for r.Seq() != p.Seq() || r.Len() != p.obs.Items().Len() {
+		if time.Now().After(deadline) {
+			T.Fatal("replica late", r.Seq(), p.Seq())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !slices.Equal(r.Items(), *bag) {
+		T.Fatal("replica diverged")
+	}
+}
+
+func mutatePrimary(p *Primary[int64, Cmp2Int], rng *rand.Rand, n int) {
+	for i := 0; i < n; i++ {
+		v := rng.Intn(100)
+		switch rng.Intn(4) {
+		case 0:
+			p.Add(Cmp2Int{A: v, B: i})
+		case 1:
+			p.Append(Cmp2Int{A: v, B: i}, Cmp2Int{A: rng.Intn(100), B: i})
+		case 2:
+			p.Remove(int64(v))
+		default:
+			p.Replace(Cmp2Int{A: v, B: -i})
+		}
+	}
+}
+
+func TestReplication_Stream(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	var bag SortedObj[int64, Cmp2Int]
+	for i := 0; i < 50; i++ {
+		bag.Add(Cmp2Int{A: rng.Intn(100)})
+	}
+	p := NewPrimary(&bag, cmp2IntCodec{}, 100)
+	defer p.Close()
+	mutatePrimary(p, rng, 20)
+
+	// Bootstrap from a snapshot, then follow the changes
+	r := NewReplica[int64](cmp2IntCodec{})
+	stop := connect(p, r)
+	waitReplica(T, p, r, &bag)
+	if r.Snapshots() != 1 {
+		T.Fatal(r.Snapshots())
+	}
+	mutatePrimary(p, rng, 300)
+	waitReplica(T, p, r, &bag)
+	stop()
+
+	// A replica reconnecting within the log window catches up from the log.
+	// A replica too slow to follow the changes may also have received a
+	// snapshot while connected.
+	mutatePrimary(p, rng, 30)
+	snapshots := r.Snapshots()
+	stop = connect(p, r)
+	waitReplica(T, p, r, &bag)
+	stop()
+	if r.Snapshots() != snapshots {
+		T.Fatal(r.Snapshots())
+	}
+
+	// Beyond the log window, a snapshot is sent again
+	mutatePrimary(p, rng, 500)
+	stop = connect(p, r)
+	waitReplica(T, p, r, &bag)
+	stop()
+	if r.Snapshots() != snapshots+1 || r.Resyncs() != 0 {
+		T.Fatal(r.Snapshots(), r.Resyncs())
+	}
+}
+
+func TestReplication_Gap(T *testing.T) {
+	r := NewReplica[int64](cmp2IntCodec{})
+	a, b := net.Pipe()
+	defer a.Close()
+	done := make(chan error, 1)
+	go func() { done <- r.Run(b) }()
+
+	// A fake primary sends a snapshot at 5, then a change at 7
+	in := bufio.NewReader(a)
+	var buf []byte
+	if hello, err := readFrame(in, &buf); err != nil || hello[0] != replHello || hello[len(hello)-1] != 0 {
+		T.Fatal(hello, err)
+	}
+	var codec cmp2IntCodec
+	snapshot := binary.AppendUvarint([]byte{replSnapshot}, 5)
+	snapshot = binary.AppendUvarint(snapshot, 1)
+	change := binary.AppendUvarint([]byte{replEvent}, 7)
+	change = append(change, byte(EventInserted), 0)
+	change = codec.AppendItem(change, Cmp2Int{A: 2})
+	for _, payload := range [][]byte{snapshot, codec.AppendItem(nil, Cmp2Int{A: 1}), change} {
+		if _, err := a.Write(appendWALRecord(nil, payload)); err != nil {
+			T.Fatal(err)
+		}
+	}
+
+	// The replica detects the gap and asks for a resynchronization
+	hello, err := readFrame(in, &buf)
+	if err != nil || hello[0] != replHello || hello[len(hello)-1] != 0 {
+		T.Fatal(hello, err)
+	}
+	if r.Seq() != 5 || r.Len() != 1 || r.Resyncs() != 1 {
+		T.Fatal(r.Seq(), r.Len(), r.Resyncs())
+	}
+	a.Close()
+	<-done
+}
+
+// failingConn delivers the frames it holds and fails all the writes
+type failingConn struct{ r *bytes.Reader }
+
+func (c failingConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c failingConn) Write(b []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestReplication_WriteFailure(T *testing.T) {
+	var bag SortedObj[int64, Cmp2Int]
+	bag.Add(Cmp2Int{A: 1})
+	p := NewPrimary(&bag, cmp2IntCodec{}, 10)
+	defer p.Close()
+
+	before := runtime.NumGoroutine()
+	hello := appendWALRecord(nil, []byte{replHello, 0, 0})
+	conn := failingConn{r: bytes.NewReader(append(slices.Clone(hello), hello...))}
+	if err := p.Serve(conn); !errors.Is(err, io.ErrClosedPipe) {
+		T.Fatal(err)
+	}
+	// The reader blocked on the second hello exits with Serve
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			T.Fatal("reader leaked", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
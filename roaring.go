@@ -0,0 +1,359 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/bits"
+	"slices"
+	"sort"
+)
+
+const (
+	// roaringArrayMax is the cardinality above which a chunk is stored as a
+	// bitmap: 4096 uint16 take as much memory as the 8KiB of a bitmap.
+	roaringArrayMax = 4096
+	// roaringArrayMin is the cardinality below which a bitmap goes back to
+	// an array. The gap with roaringArrayMax prevents a chunk oscillating
+	// around the threshold from being converted on every operation.
+	roaringArrayMin = 2048
+
+	roaringBitmapWords = 1 << 16 / 64
+)
+
+// RoaringRaw implements a sorted set of 16-bit or 32-bit unsigned integers.
+// The values are grouped in chunks sharing the same 16 upper bits. A sparse
+// chunk is stored as a sorted array of the lower 16 bits, a dense chunk as
+// a bitmap of 65536 bits, and a chunk switches automatically between both
+// representations, with some hysteresis. Dense sets then cost about one bit per value instead of
+// four bytes.
+// The set algebra (And, Or, AndNot) works chunk by chunk, with word-wise
+// operations between bitmaps.
+// The zero RoaringRaw is an empty set ready to use.
+type RoaringRaw[T ~uint16 | ~uint32] struct {
+	highs      []uint16
+	containers []*roaringContainer
+}
+
+// roaringContainer holds a chunk either as an array or as a bitmap
+type roaringContainer struct {
+	// array holds the sorted values, nil for a bitmap
+	array []uint16
+	// bitmap holds the values as bits, nil for an array
+	bitmap []uint64
+	card   int
+}
+
+// Len returns the number of values in the set
+func (s *RoaringRaw[T]) Len() int {
+	total := 0
+	for _, c := range s.containers {
+		total += c.card
+	}
+	return total
+}
+
+func (s *RoaringRaw[T]) find(high uint16) (int, bool) {
+	return slices.BinarySearch(s.highs, high)
+}
+
+// Add introduces the value in the set
+func (s *RoaringRaw[T]) Add(a T) {
+	high, low := uint16(uint32(a)>>16), uint16(a)
+	i, found := s.find(high)
+	if !found {
+		s.highs = slices.Insert(s.highs, i, high)
+		s.containers = slices.Insert(s.containers, i, &roaringContainer{})
+	}
+	s.containers[i].add(low)
+}
+
+// Append introduces several values in the set
+func (s *RoaringRaw[T]) Append(a ...T) {
+	for _, x := range a {
+		s.Add(x)
+	}
+}
+
+// Remove removes the value from the set
+func (s *RoaringRaw[T]) Remove(a T) {
+	high, low := uint16(uint32(a)>>16), uint16(a)
+	if i, found := s.find(high); found {
+		c := s.containers[i]
+		c.remove(low)
+		if c.card == 0 {
+			s.highs = slices.Delete(s.highs, i, i+1)
+			s.containers = slices.Delete(s.containers, i, i+1)
+		}
+	}
+}
+
+// Has tests for the presence of the value in the set
+func (s *RoaringRaw[T]) Has(id T) bool {
+	i, found := s.find(uint16(uint32(id) >> 16))
+	return found && s.containers[i].has(uint16(id))
+}
+
+// Get tests for the presence of the value in the set and returns it
+func (s *RoaringRaw[T]) Get(id T) (out T, ok bool) {
+	if s.Has(id) {
+		return id, true
+	}
+	return out, false
+}
+
+// Slice returns at most max values strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (s *RoaringRaw[T]) Slice(marker T, max uint32) []T {
+	out := make([]T, 0)
+	max = boundSliceSize(max)
+	high := uint16(uint32(marker) >> 16)
+	i := sort.Search(len(s.highs), func(i int) bool { return s.highs[i] >= high })
+	for ; i < len(s.highs) && uint32(len(out)) < max; i++ {
+		from := 0
+		if s.highs[i] == high {
+			from = int(uint16(marker)) + 1
+		}
+		base := uint32(s.highs[i]) << 16
+		s.containers[i].each(from, func(low uint16) bool {
+			out = append(out, T(base|uint32(low)))
+			return uint32(len(out)) < max
+		})
+	}
+	return out
+}
+
+// Each calls the hook on every value, in order, until the hook returns false
+func (s *RoaringRaw[T]) Each(hook func(x T) bool) {
+	for i, c := range s.containers {
+		base := uint32(s.highs[i]) << 16
+		more := true
+		c.each(0, func(low uint16) bool {
+			more = hook(T(base | uint32(low)))
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+// And returns the intersection of both sets
+func (s *RoaringRaw[T]) And(other *RoaringRaw[T]) *RoaringRaw[T] {
+	out := &RoaringRaw[T]{}
+	for i, j := 0, 0; i < len(s.highs) && j < len(other.highs); {
+		switch {
+		case s.highs[i] < other.highs[j]:
+			i++
+		case s.highs[i] > other.highs[j]:
+			j++
+		default:
+			out.push(s.highs[i], s.containers[i].and(other.containers[j]))
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// Or returns the union of both sets
+func (s *RoaringRaw[T]) Or(other *RoaringRaw[T]) *RoaringRaw[T] {
+	out := &RoaringRaw[T]{}
+	i, j := 0, 0
+	for i < len(s.highs) || j < len(other.highs) {
+		switch {
+		case j >= len(other.highs) || (i < len(s.highs) && s.highs[i] < other.highs[j]):
+			out.push(s.highs[i], s.containers[i].clone())
+			i++
+		case i >= len(s.highs) || s.highs[i] > other.highs[j]:
+			out.push(other.highs[j], other.containers[j].clone())
+			j++
+		default:
+			out.push(s.highs[i], s.containers[i].or(other.containers[j]))
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// AndNot returns the values of the set that are absent from the other set
+func (s *RoaringRaw[T]) AndNot(other *RoaringRaw[T]) *RoaringRaw[T] {
+	out := &RoaringRaw[T]{}
+	for i := range s.highs {
+		if j, found := other.find(s.highs[i]); found {
+			out.push(s.highs[i], s.containers[i].andNot(other.containers[j]))
+		} else {
+			out.push(s.highs[i], s.containers[i].clone())
+		}
+	}
+	return out
+}
+
+// push appends a chunk greater than all the others, unless it is empty
+func (s *RoaringRaw[T]) push(high uint16, c *roaringContainer) {
+	if c.card > 0 {
+		s.highs = append(s.highs, high)
+		s.containers = append(s.containers, c)
+	}
+}
+
+func (c *roaringContainer) has(low uint16) bool {
+	if c.bitmap != nil {
+		return c.bitmap[low/64]&(1<<(low%64)) != 0
+	}
+	_, found := slices.BinarySearch(c.array, low)
+	return found
+}
+
+func (c *roaringContainer) add(low uint16) {
+	if c.bitmap != nil {
+		if c.bitmap[low/64]&(1<<(low%64)) == 0 {
+			c.bitmap[low/64] |= 1 << (low % 64)
+			c.card++
+		}
+		return
+	}
+	if i, found := slices.BinarySearch(c.array, low); !found {
+		c.array = slices.Insert(c.array, i, low)
+		c.card++
+		c.normalize()
+	}
+}
+
+func (c *roaringContainer) remove(low uint16) {
+	if c.bitmap != nil {
+		if c.bitmap[low/64]&(1<<(low%64)) != 0 {
+			c.bitmap[low/64] &^= 1 << (low % 64)
+			c.card--
+			c.normalize()
+		}
+		return
+	}
+	if i, found := slices.BinarySearch(c.array, low); found {
+		c.array = slices.Delete(c.array, i, i+1)
+		c.card--
+	}
+}
+
+// each calls the hook on the values not lesser than from, until the hook returns false
+func (c *roaringContainer) each(from int, hook func(low uint16) bool) {
+	if from > 0xFFFF {
+		return
+	}
+	if c.bitmap == nil {
+		i, _ := slices.BinarySearch(c.array, uint16(from))
+		for _, low := range c.array[i:] {
+			if !hook(low) {
+				return
+			}
+		}
+		return
+	}
+	for w := from / 64; w < len(c.bitmap); w++ {
+		word := c.bitmap[w]
+		if w == from/64 {
+			word &^= (1 << (from % 64)) - 1
+		}
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			if !hook(uint16(w*64 + bit)) {
+				return
+			}
+			word &= word - 1
+		}
+	}
+}
+
+// normalize switches to the representation that best fits the cardinality
+func (c *roaringContainer) normalize() {
+	if c.bitmap == nil && c.card > roaringArrayMax {
+		c.bitmap = make([]uint64, roaringBitmapWords)
+		for _, low := range c.array {
+			c.bitmap[low/64] |= 1 << (low % 64)
+		}
+		c.array = nil
+	} else if c.bitmap != nil && c.card < roaringArrayMin {
+		array := make([]uint16, 0, c.card)
+		c.each(0, func(low uint16) bool {
+			array = append(array, low)
+			return true
+		})
+		c.array, c.bitmap = array, nil
+	}
+}
+
+func (c *roaringContainer) clone() *roaringContainer {
+	return &roaringContainer{array: slices.Clone(c.array), bitmap: slices.Clone(c.bitmap), card: c.card}
+}
+
+func (c *roaringContainer) and(other *roaringContainer) *roaringContainer {
+	if c.bitmap != nil && other.bitmap != nil {
+		out := &roaringContainer{bitmap: make([]uint64, roaringBitmapWords)}
+		for w := range out.bitmap {
+			out.bitmap[w] = c.bitmap[w] & other.bitmap[w]
+			out.card += bits.OnesCount64(out.bitmap[w])
+		}
+		out.normalize()
+		return out
+	}
+	if c.bitmap != nil {
+		c, other = other, c
+	}
+	out := &roaringContainer{}
+	for _, low := range c.array {
+		if other.has(low) {
+			out.array = append(out.array, low)
+		}
+	}
+	out.card = len(out.array)
+	return out
+}
+
+func (c *roaringContainer) or(other *roaringContainer) *roaringContainer {
+	if c.bitmap == nil && other.bitmap != nil {
+		c, other = other, c
+	}
+	out := c.clone()
+	if out.bitmap != nil && other.bitmap != nil {
+		out.card = 0
+		for w := range out.bitmap {
+			out.bitmap[w] |= other.bitmap[w]
+			out.card += bits.OnesCount64(out.bitmap[w])
+		}
+		return out
+	}
+	for _, low := range other.array {
+		out.add(low)
+	}
+	return out
+}
+
+func (c *roaringContainer) andNot(other *roaringContainer) *roaringContainer {
+	if c.bitmap == nil {
+		out := &roaringContainer{}
+		for _, low := range c.array {
+			if !other.has(low) {
+				out.array = append(out.array, low)
+			}
+		}
+		out.card = len(out.array)
+		return out
+	}
+	out := c.clone()
+	if other.bitmap != nil {
+		out.card = 0
+		for w := range out.bitmap {
+			out.bitmap[w] &^= other.bitmap[w]
+			out.card += bits.OnesCount64(out.bitmap[w])
+		}
+		out.normalize()
+		return out
+	}
+	for _, low := range other.array {
+		out.remove(low)
+	}
+	return out
+}
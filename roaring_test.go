@@ -0,0 +1,157 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// randomRoaring returns a set mixing sparse and dense chunks, and its
+// reference content
+func randomRoaring(rng *rand.Rand) (*RoaringRaw[uint32], map[uint32]bool) {
+	var bag RoaringRaw[uint32]
+	ref := make(map[uint32]bool)
+	for i := 0; i < 20000; i++ {
+		var v uint32
+		switch rng.Intn(3) {
+		case 0:
+			v = uint32(rng.Intn(1 << 14)) // dense chunk 0
+		case 1:
+			v = 3<<16 | uint32(rng.Intn(1<<16)) // sparse chunk 3
+		default:
+			v = rng.Uint32()
+		}
+		bag.Add(v)
+		ref[v] = true
+	}
+	return &bag, ref
+}
+
+func (s *RoaringRaw[V]) check(T *testing.T, ref map[V]bool) {
+	if s.Len() != len(ref) {
+		T.Fatal("len", s.Len(), len(ref))
+	}
+	var last V
+	count := 0
+	s.Each(func(x V) bool {
+		if count > 0 && x <= last {
+			T.Fatal("unsorted")
+		}
+		if !ref[x] {
+			T.Fatal("unexpected", x)
+		}
+		last = x
+		count++
+		return true
+	})
+	for i, c := range s.containers {
+		if c.card == 0 || (c.bitmap == nil && c.card > roaringArrayMax) || (c.bitmap != nil && c.card < roaringArrayMin) {
+			T.Fatal("bad container", i, c.card)
+		}
+	}
+}
+
+func TestRoaring_AddRemove(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	bag, ref := randomRoaring(rng)
+	bag.check(T, ref)
+	if bag.containers[0].bitmap == nil {
+		T.Fatal("dense chunk should be a bitmap")
+	}
+	for v := range ref {
+		if !bag.Has(v) {
+			T.Fatal(v)
+		}
+		if v%2 == 0 {
+			bag.Remove(v)
+			delete(ref, v)
+		}
+	}
+	bag.check(T, ref)
+	for v := range ref {
+		bag.Remove(v)
+	}
+	if bag.Len() != 0 || len(bag.containers) != 0 {
+		T.Fatal()
+	}
+}
+
+func TestRoaring_Slice(T *testing.T) {
+	var bag RoaringRaw[uint16]
+	for i := 0; i < 3*MaxSliceSize; i++ {
+		bag.Add(uint16(2 * i))
+	}
+	s := bag.Slice(10, 3)
+	if len(s) != 3 || s[0] != 12 || s[2] != 16 {
+		T.Fatal(s)
+	}
+	if s = bag.Slice(0, MaxSliceSize+1); len(s) != MaxSliceSize {
+		T.Fatal(len(s))
+	}
+	if s = bag.Slice(0xFFFF, 10); len(s) != 0 {
+		T.Fatal(s)
+	}
+
+	var wide RoaringRaw[uint32]
+	wide.Append(1, 1<<16, 1<<20, 0xFFFFFFFF)
+	if s := wide.Slice(1, 10); len(s) != 3 || s[0] != 1<<16 || s[2] != 0xFFFFFFFF {
+		T.Fatal(s)
+	}
+}
+
+func TestRoaring_Algebra(T *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	a, refA := randomRoaring(rng)
+	b, refB := randomRoaring(rng)
+
+	and, or, andNot := map[uint32]bool{}, map[uint32]bool{}, map[uint32]bool{}
+	for v := range refA {
+		or[v] = true
+		if refB[v] {
+			and[v] = true
+		} else {
+			andNot[v] = true
+		}
+	}
+	for v := range refB {
+		or[v] = true
+	}
+	a.And(b).check(T, and)
+	a.Or(b).check(T, or)
+	a.AndNot(b).check(T, andNot)
+	b.AndNot(b).check(T, map[uint32]bool{})
+	a.check(T, refA)
+	b.check(T, refB)
+}
+
+func TestRoaring_Hysteresis(T *testing.T) {
+	var bag RoaringRaw[uint16]
+	for v := 0; v <= roaringArrayMax; v++ {
+		bag.Add(uint16(v))
+	}
+	c := bag.containers[0]
+	if c.bitmap == nil {
+		T.Fatal("should be a bitmap", c.card)
+	}
+	// Oscillating around the upper mark doesn't convert back and forth
+	for i := 0; i < 10; i++ {
+		bag.Remove(0)
+		bag.Add(0)
+		if c.bitmap == nil {
+			T.Fatal("converted back to an array", c.card)
+		}
+	}
+	for v := 0; c.card >= roaringArrayMin; v++ {
+		if c.bitmap == nil {
+			T.Fatal("converted above the lower mark", c.card)
+		}
+		bag.Remove(uint16(v))
+	}
+	if c.bitmap != nil || len(c.array) != c.card {
+		T.Fatal("should be an array", c.card)
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// skipListMaxLevel bounds the height of the towers, which is enough for
+// 4^32 items with a 1/4 promotion probability.
+const skipListMaxLevel = 32
+
+// SkipListRaw implements a concurrent sorted set of raw types.
+// Has, Get and Slice never lock, Add and Remove only lock the
+// few nodes surrounding the modified position so that concurrent modifications
+// of distinct regions of the set don't contend.
+// The zero value is an empty set ready to use.
+type SkipListRaw[T Ordered] struct {
+	list skipList[T, struct{}]
+}
+
+// SkipListObj implements a concurrent sorted set of objects providing a PRIMARY KEY.
+// Two items cannot share the same PRIMARY KEY.
+// It shares the concurrency guarantees of SkipListRaw.
+// The zero value is an empty set ready to use.
+type SkipListObj[PkType Ordered, T WithPK[PkType]] struct {
+	list skipList[PkType, T]
+}
+
+// NewSkipListRaw returns an empty concurrent set
+func NewSkipListRaw[T Ordered]() *SkipListRaw[T] {
+	return &SkipListRaw[T]{}
+}
+
+// Len returns the number of items in the set. Under concurrent modifications
+// the value is only an estimation.
+func (s *SkipListRaw[T]) Len() int { return int(s.list.size.Load()) }
+
+// Add introduces the item in the set and returns true, or returns false if
+// the item was already present.
+func (s *SkipListRaw[T]) Add(a T) bool { return s.list.add(a, struct{}{}) }
+
+// Append introduces several items in the set
+func (s *SkipListRaw[T]) Append(a ...T) {
+	for _, x := range a {
+		s.list.add(x, struct{}{})
+	}
+}
+
+// Remove removes the item from the set and returns true, or returns false
+// if the item was absent.
+func (s *SkipListRaw[T]) Remove(a T) bool { return s.list.remove(a) }
+
+// Has tests for the presence of the raw item in the current set
+func (s *SkipListRaw[T]) Has(id T) bool { return s.list.find(id) != nil }
+
+// Get tests for the presence of the raw item in the current set and returns
+// a copy of the entity of it is present.
+func (s *SkipListRaw[T]) Get(id T) (out T, ok bool) {
+	if n := s.list.find(id); n != nil {
+		return n.key, true
+	}
+	return out, false
+}
+
+// Slice returns at most max items strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (s *SkipListRaw[T]) Slice(marker T, max uint32) []T {
+	out := make([]T, 0)
+	s.list.scan(marker, max, func(n *slNode[T, struct{}]) { out = append(out, n.key) })
+	return out
+}
+
+// NewSkipListObj returns an empty concurrent set
+func NewSkipListObj[PkType Ordered, T WithPK[PkType]]() *SkipListObj[PkType, T] {
+	return &SkipListObj[PkType, T]{}
+}
+
+// Len returns the number of items in the set. Under concurrent modifications
+// the value is only an estimation.
+func (s *SkipListObj[PkType, T]) Len() int { return int(s.list.size.Load()) }
+
+// Add introduces the item in the set and returns true, or returns false if
+// another item with the same PRIMARY KEY was already present.
+func (s *SkipListObj[PkType, T]) Add(a T) bool { return s.list.add(a.PK(), a) }
+
+// Append introduces several items in the set
+func (s *SkipListObj[PkType, T]) Append(a ...T) {
+	for _, x := range a {
+		s.list.add(x.PK(), x)
+	}
+}
+
+// Remove removes the item with the given PRIMARY KEY and returns true, or
+// returns false if no item had that PRIMARY KEY.
+func (s *SkipListObj[PkType, T]) Remove(pk PkType) bool { return s.list.remove(pk) }
+
+// Has tests for the presence of an item in the set, given the primary key of the item
+func (s *SkipListObj[PkType, T]) Has(id PkType) bool { return s.list.find(id) != nil }
+
+// Get returns the item with the given PRIMARY KEY
+func (s *SkipListObj[PkType, T]) Get(id PkType) (out T, ok bool) {
+	if n := s.list.find(id); n != nil {
+		return n.value, true
+	}
+	return out, false
+}
+
+// Slice returns at most max items whose PRIMARY KEY is strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (s *SkipListObj[PkType, T]) Slice(marker PkType, max uint32) []T {
+	out := make([]T, 0)
+	s.list.scan(marker, max, func(n *slNode[PkType, T]) { out = append(out, n.value) })
+	return out
+}
+
+// skipList is a lazy concurrent skip-list: the nodes are logically removed by
+// marking them before being unlinked, and are only visible once fully linked
+// at every level of their tower.
+type skipList[K Ordered, V any] struct {
+	head *slNode[K, V]
+	once sync.Once
+	size atomic.Int64
+}
+
+type slNode[K Ordered, V any] struct {
+	key         K
+	value       V
+	next        []atomic.Pointer[slNode[K, V]]
+	mu          sync.Mutex
+	marked      atomic.Bool
+	fullyLinked atomic.Bool
+}
+
+// sentinel returns the head of the list, allocated on the first use
+func (l *skipList[K, V]) sentinel() *slNode[K, V] {
+	l.once.Do(func() {
+		l.head = &slNode[K, V]{next: make([]atomic.Pointer[slNode[K, V]], skipListMaxLevel)}
+		l.head.fullyLinked.Store(true)
+	})
+	return l.head
+}
+
+func (n *slNode[K, V]) topLevel() int { return len(n.next) - 1 }
+
+func (n *slNode[K, V]) visible() bool { return n.fullyLinked.Load() && !n.marked.Load() }
+
+func randomLevel() int {
+	level := 0
+	for level < skipListMaxLevel-1 && rand.Uint32()&3 == 0 {
+		level++
+	}
+	return level
+}
+
+// lookup fills the predecessors and successors of the key at each level and
+// returns the highest level where a node with the key was found, or -1.
+func (l *skipList[K, V]) lookup(key K, preds, succs []*slNode[K, V]) int {
+	found := -1
+	pred := l.sentinel()
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		curr := pred.next[level].Load()
+		for curr != nil && curr.key < key {
+			pred, curr = curr, curr.next[level].Load()
+		}
+		if found < 0 && curr != nil && curr.key == key {
+			found = level
+		}
+		preds[level], succs[level] = pred, curr
+	}
+	return found
+}
+
+// find returns the visible node with the given key, without locking
+func (l *skipList[K, V]) find(key K) *slNode[K, V] {
+	pred := l.sentinel()
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		curr := pred.next[level].Load()
+		for curr != nil && curr.key < key {
+			pred, curr = curr, curr.next[level].Load()
+		}
+		if curr != nil && curr.key == key {
+			if curr.visible() {
+				return curr
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// lockPreds locks the distinct predecessors up to the given level and
+// returns whether they are still unmarked and linked to the successors.
+func lockPreds[K Ordered, V any](preds, succs []*slNode[K, V], top int) (locked []*slNode[K, V], valid bool) {
+	valid = true
+	var prev *slNode[K, V]
+	for level := 0; valid && level <= top; level++ {
+		pred, succ := preds[level], succs[level]
+		if pred != prev {
+			pred.mu.Lock()
+			locked = append(locked, pred)
+			prev = pred
+		}
+		valid = !pred.marked.Load() && pred.next[level].Load() == succ
+	}
+	return locked, valid
+}
+
+func unlockAll[K Ordered, V any](locked []*slNode[K, V]) {
+	for _, n := range locked {
+		n.mu.Unlock()
+	}
+}
+
+func (l *skipList[K, V]) add(key K, value V) bool {
+	var preds, succs [skipListMaxLevel]*slNode[K, V]
+	top := randomLevel()
+	for {
+		if found := l.lookup(key, preds[:], succs[:]); found >= 0 {
+			n := succs[found]
+			if !n.marked.Load() {
+				for !n.fullyLinked.Load() {
+					runtime.Gosched()
+				}
+				return false
+			}
+			// The node is being removed, retry once it is unlinked
+			continue
+		}
+		locked, valid := lockPreds(preds[:], succs[:], top)
+		for level := 0; valid && level <= top; level++ {
+			valid = succs[level] == nil || !succs[level].marked.Load()
+		}
+		if !valid {
+			unlockAll(locked)
+			continue
+		}
+		n := &slNode[K, V]{key: key, value: value, next: make([]atomic.Pointer[slNode[K, V]], top+1)}
+		for level := 0; level <= top; level++ {
+			n.next[level].Store(succs[level])
+		}
+		for level := 0; level <= top; level++ {
+			preds[level].next[level].Store(n)
+		}
+		n.fullyLinked.Store(true)
+		l.size.Add(1)
+		unlockAll(locked)
+		return true
+	}
+}
+
+func (l *skipList[K, V]) remove(key K) bool {
+	var preds, succs [skipListMaxLevel]*slNode[K, V]
+	var victim *slNode[K, V]
+	for {
+		found := l.lookup(key, preds[:], succs[:])
+		if victim == nil {
+			if found < 0 {
+				return false
+			}
+			n := succs[found]
+			if !n.fullyLinked.Load() || n.marked.Load() || n.topLevel() != found {
+				return false
+			}
+			n.mu.Lock()
+			if n.marked.Load() {
+				n.mu.Unlock()
+				return false
+			}
+			n.marked.Store(true)
+			victim = n
+		}
+		top := victim.topLevel()
+		locked, valid := lockPreds(preds[:], succs[:], top)
+		for level := 0; valid && level <= top; level++ {
+			valid = succs[level] == victim
+		}
+		if !valid {
+			unlockAll(locked)
+			continue
+		}
+		for level := top; level >= 0; level-- {
+			preds[level].next[level].Store(victim.next[level].Load())
+		}
+		l.size.Add(-1)
+		victim.mu.Unlock()
+		unlockAll(locked)
+		return true
+	}
+}
+
+// scan calls the hook on at most max visible nodes whose key is strictly
+// greater than the marker, without locking.
+func (l *skipList[K, V]) scan(marker K, max uint32, hook func(n *slNode[K, V])) {
+	max = boundSliceSize(max)
+	pred := l.sentinel()
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		curr := pred.next[level].Load()
+		for curr != nil && curr.key <= marker {
+			pred, curr = curr, curr.next[level].Load()
+		}
+	}
+	for n := pred.next[0].Load(); n != nil && max > 0; n = n.next[0].Load() {
+		if n.visible() {
+			hook(n)
+			max--
+		}
+	}
+}
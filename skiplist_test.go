@@ -0,0 +1,164 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSkipList_Lookup(T *testing.T) {
+	bag := NewSkipListRaw[int]()
+	bag.Append(3, 1, 0, 2)
+	if bag.Add(2) {
+		T.Fatal()
+	}
+	if bag.Len() != 4 {
+		T.Fatal()
+	}
+	for _, v := range []int{0, 1, 2, 3} {
+		if !bag.Has(v) {
+			T.Fatal()
+		}
+		if x, ok := bag.Get(v); !ok || x != v {
+			T.Fatal()
+		}
+	}
+	for _, v := range []int{-1, 4} {
+		if bag.Has(v) {
+			T.Fatal()
+		}
+		if bag.Remove(v) {
+			T.Fatal()
+		}
+	}
+	for _, v := range []int{3, 0, 2, 1} {
+		if !bag.Remove(v) || bag.Has(v) {
+			T.Fatal()
+		}
+	}
+	if bag.Len() != 0 {
+		T.Fatal()
+	}
+}
+
+func TestSkipList_Slice(T *testing.T) {
+	bag := NewSkipListRaw[int]()
+	for i := 0; i < 3*MaxSliceSize; i++ {
+		bag.Add(i)
+	}
+	testSlice := func(marker int, max uint32, first, count int) {
+		slice := bag.Slice(marker, max)
+		if len(slice) != count {
+			T.Fatal("marker", marker, "max", max, "len", len(slice))
+		}
+		for i, v := range slice {
+			if v != first+i {
+				T.Fatal()
+			}
+		}
+	}
+	testSlice(0, 2, 1, 2)
+	testSlice(-1, 2, 0, 2)
+	testSlice(3*MaxSliceSize-1, 1, 0, 0)
+	testSlice(-1, MinSliceSize-1, 0, MinSliceSize)
+	testSlice(-1, MaxSliceSize+1, 0, MaxSliceSize)
+}
+
+func TestSkipList_Obj(T *testing.T) {
+	bag := NewSkipListObj[int64, *Obj]()
+	bag.Append(&Obj{2}, &Obj{0}, &Obj{1})
+	if bag.Add(&Obj{1}) {
+		T.Fatal()
+	}
+	if x, ok := bag.Get(1); !ok || x.PK() != 1 {
+		T.Fatal()
+	}
+	if s := bag.Slice(0, 10); len(s) != 2 || s[0].PK() != 1 || s[1].PK() != 2 {
+		T.Fatal()
+	}
+	if !bag.Remove(1) || bag.Has(1) || bag.Len() != 2 {
+		T.Fatal()
+	}
+}
+
+func TestSkipList_Concurrent(T *testing.T) {
+	const workers, perWorker = 8, 2000
+	bag := NewSkipListRaw[int]()
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				v := i*workers + w
+				if !bag.Add(v) {
+					T.Error("add", v)
+				}
+				bag.Has(v - 1)
+				if v%3 == 0 && !bag.Remove(v) {
+					T.Error("remove", v)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	expected := 0
+	for v := 0; v < workers*perWorker; v++ {
+		if bag.Has(v) != (v%3 != 0) {
+			T.Fatal("presence", v)
+		}
+		if v%3 != 0 {
+			expected++
+		}
+	}
+	if bag.Len() != expected {
+		T.Fatal("len", bag.Len(), expected)
+	}
+	var last, total int = -1, 0
+	for marker := -1; ; {
+		slice := bag.Slice(marker, MaxSliceSize)
+		if len(slice) == 0 {
+			break
+		}
+		for _, v := range slice {
+			if v <= last {
+				T.Fatal("unsorted")
+			}
+			last = v
+		}
+		total += len(slice)
+		marker = slice[len(slice)-1]
+	}
+	if total != expected {
+		T.Fatal("scan", total, expected)
+	}
+}
+
+func TestSkipList_ZeroValue(T *testing.T) {
+	var bag SkipListRaw[int]
+	if bag.Has(1) || bag.Remove(1) || len(bag.Slice(0, 10)) != 0 {
+		T.Fatal()
+	}
+
+	// The first uses may be concurrent
+	var obj SkipListObj[int64, *Obj]
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int64) {
+			defer wg.Done()
+			if !obj.Add(&Obj{w}) {
+				T.Error("add", w)
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+	if obj.Len() != 8 || len(obj.Slice(-1, 10)) != 8 {
+		T.Fatal(obj.Len())
+	}
+}
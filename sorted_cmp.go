@@ -6,6 +6,7 @@
 package bags
 
 import (
+	"slices"
 	"sort"
 )
 
@@ -29,41 +30,24 @@ func (s SortedCmp[T]) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 // Less implements a method of the sort.Interface
 func (s SortedCmp[T]) Less(i, j int) bool { return s[i].Compare(s[j]) < 0 }
 
-// Add introduces a new item in the sorted array, regardless the presence of the same item,
+// Add introduces a new item in the sorted array, after the items that compare equal,
 // and preserves the ordering of the array.
 func (s *SortedCmp[T]) Add(a T) {
-	*s = append(*s, a)
-	switch nb := len(*s); nb {
-	case 1:
-		return
-	case 2:
-		sort.Sort(s)
-	default:
-		// Only sort the array if the last 2 element are not sorted: in other words,
-		// adding the new biggest element maintains the ordering
-		if !sort.IsSorted((*s)[nb-2:]) {
-			sort.Sort(s)
-		}
-	}
+	i, _ := slices.BinarySearchFunc(*s, a, cmpUpperBound[T])
+	*s = slices.Insert(*s, i, a)
 }
 
 // Append introduces several items in the sorted array, regardless the presence of identical  items
 // and preserves the ordering of the array.
 func (s *SortedCmp[T]) Append(a ...T) {
 	*s = append(*s, a...)
-	sort.Sort(s)
+	slices.SortStableFunc(*s, cmpCompare[T])
 }
 
 func (s SortedCmp[T]) Slice(marker T, max uint32) []T {
-	if max < MinSliceSize {
-		max = MinSliceSize
-	} else if max > MaxSliceSize {
-		max = MaxSliceSize
-	}
-	start := sort.Search(len(s), func(i int) bool {
-		return marker.Compare(s[i]) < 0
-	})
-	if start < 0 || start >= s.Len() {
+	max = boundSliceSize(max)
+	start, _ := slices.BinarySearchFunc(s, marker, cmpUpperBound[T])
+	if start >= s.Len() {
 		return s[:0]
 	}
 	remaining := uint32(s.Len() - start)
@@ -76,10 +60,7 @@ func (s SortedCmp[T]) Slice(marker T, max uint32) []T {
 // GetIndex returns the position of the first items that matches (Compare returns 0) to the given other item,
 // or -1 in case of no match.
 func (s SortedCmp[T]) GetIndex(id T) int {
-	i := sort.Search(len(s), func(i int) bool {
-		return id.Compare(s[i]) <= 0
-	})
-	if i < len(s) && id.Compare(s[i]) == 0 {
+	if i, found := slices.BinarySearchFunc(s, id, cmpCompare[T]); found {
 		return i
 	}
 	return -1
@@ -96,19 +77,34 @@ func (s SortedCmp[T]) Get(id T) (out T, ok bool) {
 // Has tests for the presence of an item in the set, given a copy of the item
 func (s SortedCmp[T]) Has(id T) bool { return s.GetIndex(id) >= 0 }
 
-// Remove forwards the call to RemovePK with the primary key of the given
-// element.
+// Remove identifies the position of the first element that compares equal to
+// the given element, and then removes it, preserving the ordering of the set.
 func (s *SortedCmp[T]) Remove(a T) {
-	idx := s.GetIndex(a)
-	if idx >= 0 && idx < len(*s) {
-		if len(*s) == 1 {
-			*s = (*s)[:0]
-		} else {
-			s.Swap(idx, s.Len()-1)
-			*s = (*s)[:s.Len()-1]
-			sort.Sort(*s)
-		}
+	if idx := s.GetIndex(a); idx >= 0 {
+		*s = slices.Delete(*s, idx, idx+1)
+	}
+}
+
+// Update applies the mutation to the first item that compares equal to the given key, then
+// moves the item after the items comparing equal to it if it doesn't compare equal to the key
+// anymore, preserving the ordering of the set. It returns false if no item matches the key.
+func (s *SortedCmp[T]) Update(key T, mutate func(x *T)) bool {
+	found, _ := s.UpdateChanged(key, mutate)
+	return found
+}
+
+// UpdateChanged works like Update and also reports if the item stopped comparing equal to the key
+func (s *SortedCmp[T]) UpdateChanged(key T, mutate func(x *T)) (found, changed bool) {
+	idx := s.GetIndex(key)
+	if idx < 0 {
+		return false, false
 	}
+	mutate(&(*s)[idx])
+	if (*s)[idx].Compare(key) == 0 {
+		return true, false
+	}
+	reposition(*s, idx, cmpUpperBound[T])
+	return true, true
 }
 
 func (s SortedCmp[T]) SearchGreater(needle T) int {
@@ -130,3 +126,14 @@ func (s SortedCmp[T]) SearchIndex(predicate func(i int) bool) int {
 	}
 	return -1
 }
+
+func cmpCompare[T WithCompare[T]](a, b T) int { return a.Compare(b) }
+
+// cmpUpperBound orders the items not greater than the target before it, so
+// that a binary search returns the position right after the last such item.
+func cmpUpperBound[T WithCompare[T]](item, target T) int {
+	if item.Compare(target) <= 0 {
+		return -1
+	}
+	return 1
+}
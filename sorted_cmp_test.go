@@ -7,6 +7,8 @@ package bags
 
 import (
 	"cmp"
+	"math/rand"
+	"slices"
 	"sort"
 	"testing"
 )
@@ -180,10 +182,10 @@ func (s SortedCmp[T]) Assert() {
 // Check validates the ordering and the unicity of the elements in the array
 func (s SortedCmp[T]) Check() error {
 	if !sort.IsSorted(s) {
-		return errUnsorted
+		return ErrUnsorted
 	}
 	if !s.areItemsUnique() {
-		return errDuplicates
+		return ErrDuplicates
 	}
 	return nil
 }
@@ -246,3 +248,54 @@ func TestCmd_SearchItem(T *testing.T) {
 		T.Fatal("idx", idx, "bag", bag)
 	}
 }
+
+func TestCmp_Update(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	var bag, expected SortedCmp[cmpTagged]
+	for i := 0; i < 200; i++ {
+		bag.Add(cmpTagged{rng.Intn(50), i})
+	}
+	expected = slices.Clone(bag)
+	for i := 0; i < 1000; i++ {
+		key, v := cmpTagged{V: rng.Intn(60)}, rng.Intn(50)
+		found, changed := bag.UpdateChanged(key, func(x *cmpTagged) { x.V, x.Tag = v, -i })
+		idx := expected.GetIndex(key)
+		if found != (idx >= 0) || changed != (found && v != key.V) {
+			T.Fatal(i, found, changed)
+		}
+		if changed {
+			expected = slices.Delete(expected, idx, idx+1)
+			expected.Add(cmpTagged{v, -i})
+		} else if found {
+			expected[idx].Tag = -i
+		}
+		if !slices.Equal(bag, expected) {
+			T.Fatal(i, "diverged")
+		}
+	}
+	if bag.Update(cmpTagged{V: 100}, func(x *cmpTagged) { T.Fatal("called") }) {
+		T.Fatal("found")
+	}
+}
+
+func BenchmarkCmp_AppendSlices(b *testing.B) {
+	var input []CmpInt
+	for _, v := range shuffledInts(1 << 14) {
+		input = append(input, CmpInt(v))
+	}
+	for i := 0; i < b.N; i++ {
+		var bag SortedCmp[CmpInt]
+		bag.Append(input...)
+	}
+}
+
+func BenchmarkCmp_AppendSortInterface(b *testing.B) {
+	var input []CmpInt
+	for _, v := range shuffledInts(1 << 14) {
+		input = append(input, CmpInt(v))
+	}
+	for i := 0; i < b.N; i++ {
+		bag := append(SortedCmp[CmpInt](nil), input...)
+		sort.Stable(bag)
+	}
+}
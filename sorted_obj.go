@@ -6,6 +6,8 @@
 package bags
 
 import (
+	"cmp"
+	"slices"
 	"sort"
 )
 
@@ -29,41 +31,24 @@ func (s SortedObj[PkType, T]) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 // Less implements a method of the sort.Interface
 func (s SortedObj[PkType, T]) Less(i, j int) bool { return s[i].PK() < s[j].PK() }
 
-// Add introduces a new item in the sorted array, regardless the presence of another item with the same PRIMARY KEY
+// Add introduces a new item in the sorted array, after the items with the same PRIMARY KEY,
 // and preserves the ordering of the array.
 func (s *SortedObj[PkType, T]) Add(a T) {
-	*s = append(*s, a)
-	switch nb := len(*s); nb {
-	case 1:
-		return
-	case 2:
-		sort.Sort(s)
-	default:
-		// Only sort the array if the last 2 element are not sorted: in other words,
-		// adding the new biggest element maintains the ordering
-		if !sort.IsSorted((*s)[nb-2:]) {
-			sort.Sort(s)
-		}
-	}
+	i, _ := slices.BinarySearchFunc(*s, a.PK(), objUpperBound[PkType, T])
+	*s = slices.Insert(*s, i, a)
 }
 
 // Append introduces several items in the sorted array, regardless the presence of other items with the same PRIMARY KEY
 // and preserves the ordering of the array.
 func (s *SortedObj[PkType, T]) Append(a ...T) {
 	*s = append(*s, a...)
-	sort.Sort(s)
+	slices.SortStableFunc(*s, objCompare[PkType, T])
 }
 
 func (s SortedObj[PkType, T]) Slice(marker PkType, max uint32) []T {
-	if max < MinSliceSize {
-		max = MinSliceSize
-	} else if max > MaxSliceSize {
-		max = MaxSliceSize
-	}
-	start := sort.Search(len(s), func(i int) bool {
-		return s[i].PK() > marker
-	})
-	if start < 0 || start >= s.Len() {
+	max = boundSliceSize(max)
+	start, _ := slices.BinarySearchFunc(s, marker, objUpperBound[PkType, T])
+	if start >= s.Len() {
 		return s[:0]
 	}
 	remaining := uint32(s.Len() - start)
@@ -76,10 +61,7 @@ func (s SortedObj[PkType, T]) Slice(marker PkType, max uint32) []T {
 // GetIndex returns -1 if no item of the array has the given PRIMARY KEY, or the position of the first
 // element with that PRIMARY KEY
 func (s SortedObj[PkType, T]) GetIndex(id PkType) int {
-	i := sort.Search(len(s), func(i int) bool {
-		return s[i].PK() >= id
-	})
-	if i < len(s) && s[i].PK() == id {
+	if i, found := slices.BinarySearchFunc(s, id, objComparePK[PkType, T]); found {
 		return i
 	}
 	return -1
@@ -96,19 +78,34 @@ func (s SortedObj[PkType, T]) Get(id PkType) (out T, ok bool) {
 // Has tests for the presence of an item in the set, given the private key of the item
 func (s SortedObj[PkType, T]) Has(id PkType) bool { return s.GetIndex(id) >= 0 }
 
-// Remove identifies the position of the element with the given PRIMARY KEY
-// and then removes it and restores the sorting of the set.
+// Remove identifies the position of the first element with the given PRIMARY KEY
+// and then removes it, preserving the ordering of the set.
 func (s *SortedObj[PkType, T]) Remove(pk PkType) {
+	if idx := s.GetIndex(pk); idx >= 0 {
+		*s = slices.Delete(*s, idx, idx+1)
+	}
+}
+
+// Update applies the mutation to the first item with the given PRIMARY KEY, then moves the
+// item after the items with its new PRIMARY KEY if the PRIMARY KEY changed, preserving the
+// ordering of the set. It returns false if no item has the given PRIMARY KEY.
+func (s *SortedObj[PkType, T]) Update(pk PkType, mutate func(x *T)) bool {
+	found, _ := s.UpdateChanged(pk, mutate)
+	return found
+}
+
+// UpdateChanged works like Update and also reports if the PRIMARY KEY of the item changed
+func (s *SortedObj[PkType, T]) UpdateChanged(pk PkType, mutate func(x *T)) (found, changed bool) {
 	idx := s.GetIndex(pk)
-	if idx >= 0 && idx < len(*s) {
-		if len(*s) == 1 {
-			*s = (*s)[:0]
-		} else {
-			s.Swap(idx, s.Len()-1)
-			*s = (*s)[:s.Len()-1]
-			sort.Sort(*s)
-		}
+	if idx < 0 {
+		return false, false
 	}
+	mutate(&(*s)[idx])
+	if (*s)[idx].PK() == pk {
+		return true, false
+	}
+	reposition(*s, idx, func(item, target T) int { return objUpperBound(item, target.PK()) })
+	return true, true
 }
 
 func (s SortedObj[PkType, T]) SearchItem(predicate func(x *T) bool) int {
@@ -126,11 +123,24 @@ func (s SortedObj[PkType, T]) SearchIndex(predicate func(i int) bool) int {
 }
 
 func (s SortedObj[PkType, T]) SearchPK(needle PkType) int {
-	i := sort.Search(len(s), func(i int) bool {
-		return s[i].PK() >= needle
-	})
-	if i < len(s) {
+	if i, _ := slices.BinarySearchFunc(s, needle, objComparePK[PkType, T]); i < len(s) {
 		return i
 	}
 	return -1
 }
+
+func objCompare[PkType Ordered, T WithPK[PkType]](a, b T) int { return cmp.Compare(a.PK(), b.PK()) }
+
+func objComparePK[PkType Ordered, T WithPK[PkType]](item T, pk PkType) int {
+	return cmp.Compare(item.PK(), pk)
+}
+
+// objUpperBound orders the items whose PRIMARY KEY is not greater than the
+// target before it, so that a binary search returns the position right after
+// the last such item.
+func objUpperBound[PkType Ordered, T WithPK[PkType]](item T, pk PkType) int {
+	if cmp.Compare(item.PK(), pk) <= 0 {
+		return -1
+	}
+	return 1
+}
@@ -6,6 +6,8 @@
 package bags
 
 import (
+	"math/rand"
+	"slices"
 	"sort"
 	"testing"
 )
@@ -165,10 +167,10 @@ func (s SortedObj[int64, T]) Assert() {
 // Check validates the ordering and the unicity of the elements in the array
 func (s SortedObj[int64, T]) Check() error {
 	if !sort.IsSorted(s) {
-		return errUnsorted
+		return ErrUnsorted
 	}
 	if !s.areItemsUnique() {
-		return errDuplicates
+		return ErrDuplicates
 	}
 	return nil
 }
@@ -221,3 +223,61 @@ func TestObj_SearchPk(T *testing.T) {
 		T.Fatal("idx", idx, "bag", bag)
 	}
 }
+
+func TestObj_Update(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	var bag, expected SortedObj[int64, Cmp2Int]
+	for i := 0; i < 200; i++ {
+		bag.Add(Cmp2Int{rng.Intn(50), i})
+	}
+	expected = slices.Clone(bag)
+	for i := 0; i < 1000; i++ {
+		pk, a := int64(rng.Intn(60)), rng.Intn(50)
+		found, changed := bag.UpdateChanged(pk, func(x *Cmp2Int) { x.A, x.B = a, -i })
+		idx := expected.GetIndex(pk)
+		if found != (idx >= 0) || changed != (found && int64(a) != pk) {
+			T.Fatal(i, found, changed)
+		}
+		if changed {
+			expected = slices.Delete(expected, idx, idx+1)
+			expected.Add(Cmp2Int{a, -i})
+		} else if found {
+			expected[idx].B = -i
+		}
+		if !slices.Equal(bag, expected) {
+			T.Fatal(i, "diverged")
+		}
+	}
+
+	// The items compare by PRIMARY KEY, the mutation applies in place
+	ptrs := SortedObj[int64, *Obj]{{pk: 1}, {pk: 2}, {pk: 3}}
+	first := ptrs[0]
+	if !ptrs.Update(1, func(x **Obj) { (*x).pk = 4 }) || ptrs[2] != first || ptrs[0].pk != 2 {
+		T.Fatal(ptrs)
+	}
+	if ptrs.Update(1, func(x **Obj) { T.Fatal("called") }) {
+		T.Fatal("found")
+	}
+}
+
+func BenchmarkObj_AppendSlices(b *testing.B) {
+	var input []*Obj
+	for _, v := range shuffledInts(1 << 14) {
+		input = append(input, &Obj{int64(v)})
+	}
+	for i := 0; i < b.N; i++ {
+		var bag SortedObj[int64, *Obj]
+		bag.Append(input...)
+	}
+}
+
+func BenchmarkObj_AppendSortInterface(b *testing.B) {
+	var input []*Obj
+	for _, v := range shuffledInts(1 << 14) {
+		input = append(input, &Obj{int64(v)})
+	}
+	for i := 0; i < b.N; i++ {
+		bag := append(SortedObj[int64, *Obj](nil), input...)
+		sort.Stable(bag)
+	}
+}
@@ -6,6 +6,8 @@
 package bags
 
 import (
+	"cmp"
+	"slices"
 	"sort"
 )
 
@@ -22,37 +24,24 @@ func (s SortedRaw[T]) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 
 func (s SortedRaw[T]) Less(i, j int) bool { return s[i] < s[j] }
 
+// Add introduces a new item in the sorted array, after the items with the same value,
+// and preserves the ordering of the array.
 func (s *SortedRaw[T]) Add(a T) {
-	*s = append(*s, a)
-	switch nb := len(*s); nb {
-	case 1:
-		return
-	case 2:
-		sort.Sort(s)
-	default:
-		// Only sort the array if the last 2 element are not sorted: in other words,
-		// adding the new biggest element maintains the ordering
-		if !sort.IsSorted((*s)[nb-2:]) {
-			sort.Sort(s)
-		}
-	}
+	i, _ := slices.BinarySearchFunc(*s, a, rawUpperBound[T])
+	*s = slices.Insert(*s, i, a)
 }
 
+// Append introduces several items in the sorted array, regardless the presence of identical items,
+// and preserves the ordering of the array.
 func (s *SortedRaw[T]) Append(a ...T) {
 	*s = append(*s, a...)
-	sort.Sort(s)
+	slices.Sort(*s)
 }
 
 func (s SortedRaw[T]) Slice(marker T, max uint32) []T {
-	if max < MinSliceSize {
-		max = MinSliceSize
-	} else if max > MaxSliceSize {
-		max = MaxSliceSize
-	}
-	start := sort.Search(len(s), func(i int) bool {
-		return s[i] > marker
-	})
-	if start < 0 || start >= s.Len() {
+	max = boundSliceSize(max)
+	start, _ := slices.BinarySearchFunc(s, marker, rawUpperBound[T])
+	if start >= s.Len() {
 		return s[:0]
 	}
 	remaining := uint32(s.Len() - start)
@@ -65,10 +54,7 @@ func (s SortedRaw[T]) Slice(marker T, max uint32) []T {
 // GetIndex returns -1 if no item of the array is identical to the given value, or the position
 // of the first element.
 func (s SortedRaw[T]) GetIndex(id T) int {
-	i := sort.Search(len(s), func(i int) bool {
-		return s[i] >= id
-	})
-	if i < len(s) && s[i] == id {
+	if i, found := slices.BinarySearch(s, id); found {
 		return i
 	}
 	return -1
@@ -87,18 +73,11 @@ func (s SortedRaw[T]) Get(id T) (out T, ok bool) {
 // Has tests for the presence of the raw item in the current set
 func (s SortedRaw[T]) Has(id T) bool { return s.GetIndex(id) >= 0 }
 
-// Remove identifies the position of the element with the given primary key
-// and then removes it and restores the sorting of the set.
+// Remove identifies the position of the first element with the given value
+// and then removes it, preserving the ordering of the set.
 func (s *SortedRaw[T]) Remove(a T) {
-	idx := s.GetIndex(a)
-	if idx >= 0 && idx < len(*s) {
-		if len(*s) == 1 {
-			*s = (*s)[:0]
-		} else {
-			s.Swap(idx, s.Len()-1)
-			*s = (*s)[:s.Len()-1]
-			sort.Sort(*s)
-		}
+	if idx := s.GetIndex(a); idx >= 0 {
+		*s = slices.Delete(*s, idx, idx+1)
 	}
 }
 
@@ -115,3 +94,12 @@ func (s SortedRaw[T]) SearchIndex(predicate func(i int) bool) int {
 	}
 	return -1
 }
+
+// rawUpperBound orders the items not greater than the target before it, so
+// that a binary search returns the position right after the last such item.
+func rawUpperBound[T Ordered](item, target T) int {
+	if cmp.Compare(item, target) <= 0 {
+		return -1
+	}
+	return 1
+}
@@ -6,6 +6,7 @@
 package bags
 
 import (
+	"math/rand"
 	"sort"
 	"testing"
 )
@@ -173,10 +174,10 @@ func (s SortedRaw[int]) Assert() {
 // Check validates the ordering and the unicity of the elements in the array
 func (s SortedRaw[int]) Check() error {
 	if !sort.IsSorted(s) {
-		return errUnsorted
+		return ErrUnsorted
 	}
 	if !s.areItemsUnique() {
-		return errDuplicates
+		return ErrDuplicates
 	}
 	return nil
 }
@@ -195,3 +196,41 @@ func (s SortedRaw[int]) areItemsUnique() bool {
 	}
 	return true
 }
+
+func shuffledInts(n int) []int {
+	rng := rand.New(rand.NewSource(0))
+	return rng.Perm(n)
+}
+
+func BenchmarkRaw_AppendSlices(b *testing.B) {
+	input := shuffledInts(1 << 14)
+	for i := 0; i < b.N; i++ {
+		var bag SortedRaw[int]
+		bag.Append(input...)
+	}
+}
+
+func BenchmarkRaw_AppendSortInterface(b *testing.B) {
+	input := shuffledInts(1 << 14)
+	for i := 0; i < b.N; i++ {
+		bag := append(SortedRaw[int](nil), input...)
+		sort.Sort(bag)
+	}
+}
+
+func BenchmarkRaw_GetIndexSlices(b *testing.B) {
+	var bag SortedRaw[int]
+	bag.Append(shuffledInts(1 << 14)...)
+	for i := 0; i < b.N; i++ {
+		bag.GetIndex(i % bag.Len())
+	}
+}
+
+func BenchmarkRaw_GetIndexSortSearch(b *testing.B) {
+	var bag SortedRaw[int]
+	bag.Append(shuffledInts(1 << 14)...)
+	for i := 0; i < b.N; i++ {
+		id := i % bag.Len()
+		sort.Search(len(bag), func(i int) bool { return bag[i] >= id })
+	}
+}
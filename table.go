@@ -0,0 +1,373 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+const (
+	// DefaultTableBlockSize is the size above which a data block of a table is closed
+	DefaultTableBlockSize = 4096
+
+	tableMagic      = "BAGSST01"
+	tableFooterSize = 32
+)
+
+// Codec encodes and decodes the items stored in a table
+type Codec[T any] interface {
+	// AppendItem appends the encoding of the item to the buffer
+	AppendItem(buf []byte, x T) []byte
+	// DecodeItem decodes an item from the whole buffer
+	DecodeItem(buf []byte) (T, error)
+}
+
+// The table format is made of
+//   - the data blocks: each block is a sequence of records, each record
+//     being the uvarint length of the encoded item followed by the item, and
+//     the block ends with the CRC32 (IEEE) of its records;
+//   - the index block: for each data block, the uvarint offset, size and
+//     number of items of the block, then the length-prefixed encoding of its
+//     first item; the index block ends with its CRC32;
+//   - the 32-byte footer: the magic, then the offset and the size of the index
+//     block and the number of items, as little-endian uint64.
+
+// TableWriter streams sorted items into the table format.
+// The data blocks are written as soon as they are full, the index block and
+// the footer are written by Close.
+type TableWriter[K Ordered, T any] struct {
+	w         io.Writer
+	codec     Codec[T]
+	key       func(x T) K
+	blockSize int
+
+	offset     uint64
+	block      []byte
+	blockCount int
+	blockFirst []byte
+	index      []byte
+	count      uint64
+	last       K
+	record     []byte
+	err        error
+}
+
+// NewTableWriter returns a writer of the items to w, where the items are ordered by the key.
+func NewTableWriter[K Ordered, T any](w io.Writer, codec Codec[T], key func(x T) K) *TableWriter[K, T] {
+	return &TableWriter[K, T]{w: w, codec: codec, key: key, blockSize: DefaultTableBlockSize}
+}
+
+// Append adds an item after the previous ones. The key of the item must not
+// be lesser than the key of the previous item.
+func (tw *TableWriter[K, T]) Append(x T) error {
+	if tw.err != nil {
+		return tw.err
+	}
+	k := tw.key(x)
+	if tw.count > 0 && k < tw.last {
+		return &OrderError{Index: int(tw.count), Err: ErrUnsorted}
+	}
+	tw.record = tw.codec.AppendItem(tw.record[:0], x)
+	if tw.blockCount == 0 {
+		tw.blockFirst = append(tw.blockFirst[:0], tw.record...)
+	}
+	tw.block = binary.AppendUvarint(tw.block, uint64(len(tw.record)))
+	tw.block = append(tw.block, tw.record...)
+	tw.blockCount++
+	tw.count++
+	tw.last = k
+	if len(tw.block) >= tw.blockSize {
+		tw.err = tw.flushBlock()
+	}
+	return tw.err
+}
+
+func (tw *TableWriter[K, T]) flushBlock() error {
+	if tw.blockCount == 0 {
+		return nil
+	}
+	tw.block = binary.LittleEndian.AppendUint32(tw.block, crc32.ChecksumIEEE(tw.block))
+	if _, err := tw.w.Write(tw.block); err != nil {
+		return err
+	}
+	tw.index = binary.AppendUvarint(tw.index, tw.offset)
+	tw.index = binary.AppendUvarint(tw.index, uint64(len(tw.block)))
+	tw.index = binary.AppendUvarint(tw.index, uint64(tw.blockCount))
+	tw.index = binary.AppendUvarint(tw.index, uint64(len(tw.blockFirst)))
+	tw.index = append(tw.index, tw.blockFirst...)
+	tw.offset += uint64(len(tw.block))
+	tw.block = tw.block[:0]
+	tw.blockCount = 0
+	return nil
+}
+
+// Close writes the pending data block, the index block and the footer.
+// It doesn't close the underlying writer.
+func (tw *TableWriter[K, T]) Close() error {
+	if tw.err != nil {
+		return tw.err
+	}
+	if tw.err = tw.flushBlock(); tw.err != nil {
+		return tw.err
+	}
+	tw.index = binary.LittleEndian.AppendUint32(tw.index, crc32.ChecksumIEEE(tw.index))
+	footer := make([]byte, 0, tableFooterSize)
+	footer = append(footer, tableMagic...)
+	footer = binary.LittleEndian.AppendUint64(footer, tw.offset)
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(tw.index)))
+	footer = binary.LittleEndian.AppendUint64(footer, tw.count)
+	if _, tw.err = tw.w.Write(append(tw.index, footer...)); tw.err != nil {
+		return tw.err
+	}
+	tw.err = fmt.Errorf("%w: table closed", ErrFormat)
+	return nil
+}
+
+// WriteTableRaw writes the sorted array in the table format
+func WriteTableRaw[T Ordered](w io.Writer, s SortedRaw[T], codec Codec[T]) error {
+	tw := NewTableWriter(w, codec, func(x T) T { return x })
+	for _, x := range s {
+		if err := tw.Append(x); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// WriteTableObj writes the sorted array in the table format
+func WriteTableObj[PkType Ordered, T WithPK[PkType]](w io.Writer, s SortedObj[PkType, T], codec Codec[T]) error {
+	tw := NewTableWriter(w, codec, func(x T) PkType { return x.PK() })
+	for _, x := range s {
+		if err := tw.Append(x); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Table implements a read-only sorted bag stored in the table format.
+// Only the index block is kept in memory, the lookups binary-search the index
+// and then read and decode a single data block.
+// A Table is safe for concurrent use if its io.ReaderAt is.
+type Table[K Ordered, T any] struct {
+	r     io.ReaderAt
+	codec Codec[T]
+	key   func(x T) K
+
+	firsts  []K
+	offsets []int64
+	sizes   []int
+	ranks   []int
+	count   int
+}
+
+// OpenTableRaw opens a table written by WriteTableRaw
+func OpenTableRaw[T Ordered](r io.ReaderAt, size int64, codec Codec[T]) (*Table[T, T], error) {
+	return OpenTable(r, size, codec, func(x T) T { return x })
+}
+
+// OpenTableObj opens a table written by WriteTableObj
+func OpenTableObj[PkType Ordered, T WithPK[PkType]](r io.ReaderAt, size int64, codec Codec[T]) (*Table[PkType, T], error) {
+	return OpenTable(r, size, codec, func(x T) PkType { return x.PK() })
+}
+
+// OpenTable opens a table written by a TableWriter, and loads its index
+func OpenTable[K Ordered, T any](r io.ReaderAt, size int64, codec Codec[T], key func(x T) K) (*Table[K, T], error) {
+	if size < tableFooterSize {
+		return nil, fmt.Errorf("%w: table too short", ErrFormat)
+	}
+	footer := make([]byte, tableFooterSize)
+	if n, err := r.ReadAt(footer, size-tableFooterSize); n < len(footer) {
+		return nil, unexpected(err)
+	}
+	if string(footer[:8]) != tableMagic {
+		return nil, fmt.Errorf("%w: bad table magic", ErrFormat)
+	}
+	indexOffset := binary.LittleEndian.Uint64(footer[8:])
+	indexSize := binary.LittleEndian.Uint64(footer[16:])
+	if indexSize < 4 || indexOffset+indexSize != uint64(size-tableFooterSize) {
+		return nil, fmt.Errorf("%w: bad index position", ErrFormat)
+	}
+	index, err := readChecked(r, int64(indexOffset), int(indexSize))
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Table[K, T]{r: r, codec: codec, key: key, count: int(binary.LittleEndian.Uint64(footer[24:]))}
+	rank := 0
+	for len(index) > 0 {
+		var fields [4]uint64
+		for i := range fields {
+			v, n := binary.Uvarint(index)
+			if n <= 0 {
+				return nil, fmt.Errorf("%w: bad index entry", ErrFormat)
+			}
+			fields[i], index = v, index[n:]
+		}
+		if fields[3] > uint64(len(index)) || fields[0]+fields[1] > indexOffset {
+			return nil, fmt.Errorf("%w: bad index entry", ErrFormat)
+		}
+		first, err := codec.DecodeItem(index[:fields[3]])
+		if err != nil {
+			return nil, err
+		}
+		index = index[fields[3]:]
+		t.firsts = append(t.firsts, key(first))
+		t.offsets = append(t.offsets, int64(fields[0]))
+		t.sizes = append(t.sizes, int(fields[1]))
+		t.ranks = append(t.ranks, rank)
+		rank += int(fields[2])
+	}
+	if rank != t.count {
+		return nil, fmt.Errorf("%w: bad item count", ErrFormat)
+	}
+	return t, nil
+}
+
+// readChecked reads a block and validates its trailing CRC32, that is stripped
+func readChecked(r io.ReaderAt, offset int64, size int) ([]byte, error) {
+	if size < 4 {
+		return nil, fmt.Errorf("%w: block too short", ErrFormat)
+	}
+	buf := make([]byte, size)
+	// ReadAt may return io.EOF along with a complete read at the end of the input
+	if n, err := r.ReadAt(buf, offset); n < size {
+		return nil, unexpected(err)
+	}
+	body := buf[:size-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(buf[size-4:]) {
+		return nil, ErrChecksum
+	}
+	return body, nil
+}
+
+// Len returns the number of items in the table
+func (t *Table[K, T]) Len() int { return t.count }
+
+// readBlock loads and decodes the items of the given data block
+func (t *Table[K, T]) readBlock(b int) ([]T, error) {
+	body, err := readChecked(t.r, t.offsets[b], t.sizes[b])
+	if err != nil {
+		return nil, err
+	}
+	var items []T
+	for len(body) > 0 {
+		size, n := binary.Uvarint(body)
+		if n <= 0 || size > uint64(len(body)-n) {
+			return nil, fmt.Errorf("%w: bad record", ErrFormat)
+		}
+		x, err := t.codec.DecodeItem(body[n : n+int(size)])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, x)
+		body = body[n+int(size):]
+	}
+	return items, nil
+}
+
+// seek returns the block and the position in the block of the first item
+// whose key doesn't match the predicate before. The block is nil if no item
+// matches.
+func (t *Table[K, T]) seek(before func(k K) bool) ([]T, int, int, error) {
+	b := sort.Search(len(t.firsts), func(i int) bool { return !before(t.firsts[i]) }) - 1
+	if b < 0 {
+		b = 0
+	}
+	for ; b < len(t.firsts); b++ {
+		items, err := t.readBlock(b)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if pos := sort.Search(len(items), func(i int) bool { return !before(t.key(items[i])) }); pos < len(items) {
+			return items, pos, b, nil
+		}
+	}
+	return nil, 0, 0, nil
+}
+
+// GetIndex returns -1 if no item of the table has the given key, or the position of the first
+// item with that key
+func (t *Table[K, T]) GetIndex(id K) (int, error) {
+	items, pos, b, err := t.seek(func(k K) bool { return k < id })
+	if err != nil || items == nil || t.key(items[pos]) != id {
+		return -1, err
+	}
+	return t.ranks[b] + pos, nil
+}
+
+// Get returns the first item with the given key
+func (t *Table[K, T]) Get(id K) (out T, ok bool, err error) {
+	items, pos, _, err := t.seek(func(k K) bool { return k < id })
+	if err != nil || items == nil || t.key(items[pos]) != id {
+		return out, false, err
+	}
+	return items[pos], true, nil
+}
+
+// Has tests for the presence of an item in the table, given its key
+func (t *Table[K, T]) Has(id K) (bool, error) {
+	_, ok, err := t.Get(id)
+	return ok, err
+}
+
+// Slice returns at most max items whose key is strictly greater than the marker.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (t *Table[K, T]) Slice(marker K, max uint32) ([]T, error) {
+	out := make([]T, 0)
+	max = boundSliceSize(max)
+	items, pos, b, err := t.seek(func(k K) bool { return k <= marker })
+	for err == nil && items != nil {
+		remaining := int(max) - len(out)
+		if len(items)-pos > remaining {
+			return append(out, items[pos:pos+remaining]...), nil
+		}
+		out = append(out, items[pos:]...)
+		if b++; b >= len(t.firsts) {
+			break
+		}
+		items, err = t.readBlock(b)
+		pos = 0
+	}
+	return out, err
+}
+
+// RawCodec returns the codec of the raw types used by the binary encoding of SortedRaw.
+// As AppendItem cannot fail, it panics with ErrTooLong on a string longer
+// than math.MaxUint32 bytes.
+func RawCodec[T Ordered]() Codec[T] {
+	_, width := rawLayout[T]()
+	return rawCodec[T]{width: width}
+}
+
+type rawCodec[T Ordered] struct {
+	width int
+}
+
+func (c rawCodec[T]) AppendItem(buf []byte, x T) []byte {
+	buf, err := appendRaw(buf, x, c.width)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func (c rawCodec[T]) DecodeItem(buf []byte) (x T, err error) {
+	if c.width == 0 {
+		if len(buf) < 4 || int(binary.LittleEndian.Uint32(buf)) != len(buf)-4 {
+			return x, fmt.Errorf("%w: bad string record", ErrFormat)
+		}
+		return decodeRawString[T](buf[4:]), nil
+	}
+	if len(buf) != c.width {
+		return x, fmt.Errorf("%w: bad record width", ErrFormat)
+	}
+	return decodeRaw[T](buf, c.width), nil
+}
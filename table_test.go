@@ -0,0 +1,132 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// cmp2IntCodec encodes a Cmp2Int as two little-endian uint64
+type cmp2IntCodec struct{}
+
+func (cmp2IntCodec) AppendItem(buf []byte, x Cmp2Int) []byte {
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(x.A))
+	return binary.LittleEndian.AppendUint64(buf, uint64(x.B))
+}
+
+func (cmp2IntCodec) DecodeItem(buf []byte) (Cmp2Int, error) {
+	if len(buf) != 16 {
+		return Cmp2Int{}, ErrFormat
+	}
+	return Cmp2Int{int(binary.LittleEndian.Uint64(buf)), int(binary.LittleEndian.Uint64(buf[8:]))}, nil
+}
+
+func TestTable_Raw(T *testing.T) {
+	var bag SortedRaw[string]
+	for i := 0; i < 5000; i++ {
+		bag.Add(fmt.Sprintf("key-%05d", 2*i))
+	}
+	var buf bytes.Buffer
+	if err := WriteTableRaw(&buf, bag, RawCodec[string]()); err != nil {
+		T.Fatal(err)
+	}
+	data := buf.Bytes()
+	table, err := OpenTableRaw(bytes.NewReader(data), int64(len(data)), RawCodec[string]())
+	if err != nil {
+		T.Fatal(err)
+	}
+	if table.Len() != bag.Len() || len(table.firsts) < 10 {
+		T.Fatal(table.Len(), len(table.firsts))
+	}
+	for i := -1; i <= 10001; i += 7 {
+		v := fmt.Sprintf("key-%05d", i)
+		ok, err := table.Has(v)
+		if err != nil || ok != bag.Has(v) {
+			T.Fatal(v, err)
+		}
+		if idx, err := table.GetIndex(v); err != nil || idx != bag.GetIndex(v) {
+			T.Fatal(v, idx, err)
+		}
+		expected := bag.Slice(v, 300)
+		got, err := table.Slice(v, 300)
+		if err != nil || len(got) != len(expected) {
+			T.Fatal(v, len(got), len(expected), err)
+		}
+		for j := range got {
+			if got[j] != expected[j] {
+				T.Fatal(v, j)
+			}
+		}
+	}
+	if got, err := table.Slice("", MaxSliceSize+1); err != nil || len(got) != MaxSliceSize {
+		T.Fatal(len(got), err)
+	}
+}
+
+func TestTable_Obj(T *testing.T) {
+	var bag SortedObj[int64, Cmp2Int]
+	for i := 0; i < 1000; i++ {
+		bag.Add(Cmp2Int{i / 3, i})
+	}
+	var buf bytes.Buffer
+	if err := WriteTableObj(&buf, bag, cmp2IntCodec{}); err != nil {
+		T.Fatal(err)
+	}
+	table, err := OpenTableObj[int64](bytes.NewReader(buf.Bytes()), int64(buf.Len()), cmp2IntCodec{})
+	if err != nil {
+		T.Fatal(err)
+	}
+	for pk := int64(-1); pk < 340; pk++ {
+		x, ok, err := table.Get(pk)
+		y, ok2 := bag.Get(pk)
+		if err != nil || ok != ok2 || x != y {
+			T.Fatal(pk, x, y, err)
+		}
+	}
+}
+
+func TestTable_Errors(T *testing.T) {
+	var buf bytes.Buffer
+	tw := NewTableWriter(&buf, RawCodec[int](), func(x int) int { return x })
+	if err := tw.Append(2); err != nil {
+		T.Fatal(err)
+	}
+	if err := tw.Append(1); !errors.Is(err, ErrUnsorted) {
+		T.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		T.Fatal(err)
+	}
+	if err := tw.Append(3); err == nil {
+		T.Fatal("append after close")
+	}
+
+	data := buf.Bytes()
+	if _, err := OpenTableRaw(bytes.NewReader(data), int64(len(data)), RawCodec[int]()); err != nil {
+		T.Fatal(err)
+	}
+	corrupted := bytes.Clone(data)
+	corrupted[0] ^= 1
+	table, err := OpenTableRaw(bytes.NewReader(corrupted), int64(len(corrupted)), RawCodec[int]())
+	if err != nil {
+		T.Fatal(err)
+	}
+	if _, err = table.Has(2); !errors.Is(err, ErrChecksum) {
+		T.Fatal(err)
+	}
+	corrupted = bytes.Clone(data)
+	corrupted[len(corrupted)-tableFooterSize-1] ^= 1
+	if _, err = OpenTableRaw(bytes.NewReader(corrupted), int64(len(corrupted)), RawCodec[int]()); !errors.Is(err, ErrChecksum) {
+		T.Fatal(err)
+	}
+	if _, err = OpenTableRaw(bytes.NewReader(data[:10]), 10, RawCodec[int]()); !errors.Is(err, ErrFormat) {
+		T.Fatal(err)
+	}
+}
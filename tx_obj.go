@@ -0,0 +1,186 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"slices"
+)
+
+// TxObj buffers modifications of a SortedObj until they are either committed
+// in a single merge pass or rolled back.
+// The reads of the transaction see the pending modifications, while the
+// underlying array is left unchanged until Commit. The array must not be
+// modified by other means while the transaction has pending modifications.
+// A TxObj is built with SortedObj.Begin and remains usable after a Commit or
+// a Rollback.
+type TxObj[PkType Ordered, T WithPK[PkType]] struct {
+	bag *SortedObj[PkType, T]
+	// added holds the pending insertions
+	added SortedObj[PkType, T]
+	// removed holds, for each PRIMARY KEY, the number of items of the array
+	// with that PRIMARY KEY that are removed, the first ones.
+	removed      map[PkType]int
+	removedTotal int
+}
+
+// Begin starts a transaction on the array
+func (s *SortedObj[PkType, T]) Begin() *TxObj[PkType, T] {
+	return &TxObj[PkType, T]{bag: s, removed: make(map[PkType]int)}
+}
+
+// Pending tells if the transaction holds uncommitted modifications
+func (tx *TxObj[PkType, T]) Pending() bool {
+	return len(tx.added) > 0 || tx.removedTotal > 0
+}
+
+// Add introduces a new item, after the items with the same PRIMARY KEY
+func (tx *TxObj[PkType, T]) Add(a T) { tx.added.Add(a) }
+
+// Append introduces several items, regardless the presence of other items with the same PRIMARY KEY
+func (tx *TxObj[PkType, T]) Append(a ...T) { tx.added.Append(a...) }
+
+// Remove removes the first item with the given PRIMARY KEY, and returns
+// false if there is none.
+func (tx *TxObj[PkType, T]) Remove(pk PkType) bool {
+	if tx.removed[pk] < tx.baseCount(pk) {
+		tx.removed[pk]++
+		tx.removedTotal++
+		return true
+	}
+	if tx.added.Has(pk) {
+		tx.added.Remove(pk)
+		return true
+	}
+	return false
+}
+
+// Upsert replaces all the items with the same PRIMARY KEY by the given item,
+// and returns true if any item was replaced.
+func (tx *TxObj[PkType, T]) Upsert(a T) bool {
+	replaced := false
+	for tx.Remove(a.PK()) {
+		replaced = true
+	}
+	tx.added.Add(a)
+	return replaced
+}
+
+// Len returns the number of items, pending modifications included
+func (tx *TxObj[PkType, T]) Len() int {
+	return len(*tx.bag) - tx.removedTotal + len(tx.added)
+}
+
+// Get returns the first item with the given PRIMARY KEY, pending modifications included
+func (tx *TxObj[PkType, T]) Get(id PkType) (out T, ok bool) {
+	if idx := tx.bag.GetIndex(id); idx >= 0 && tx.removed[id] < tx.baseCount(id) {
+		return (*tx.bag)[idx+tx.removed[id]], true
+	}
+	return tx.added.Get(id)
+}
+
+// Has tests for the presence of an item, pending modifications included
+func (tx *TxObj[PkType, T]) Has(id PkType) bool {
+	_, ok := tx.Get(id)
+	return ok
+}
+
+// GetIndex returns -1 if no item has the given PRIMARY KEY, or the position the first item with
+// that PRIMARY KEY will have once the transaction is committed.
+func (tx *TxObj[PkType, T]) GetIndex(id PkType) int {
+	if !tx.Has(id) {
+		return -1
+	}
+	base, _ := slices.BinarySearchFunc(*tx.bag, id, objComparePK[PkType, T])
+	for pk, n := range tx.removed {
+		if pk < id {
+			base -= n
+		}
+	}
+	added, _ := slices.BinarySearchFunc(tx.added, id, objComparePK[PkType, T])
+	return base + added
+}
+
+// Slice returns at most max items whose PRIMARY KEY is strictly greater than the marker,
+// pending modifications included.
+// The max is bounded by MinSliceSize and MaxSliceSize.
+func (tx *TxObj[PkType, T]) Slice(marker PkType, max uint32) []T {
+	out := make([]T, 0)
+	max = boundSliceSize(max)
+	tx.merge(marker, true, func(x T) bool {
+		out = append(out, x)
+		return len(out) < int(max)
+	})
+	return out
+}
+
+// Commit applies the pending modifications to the array in a single merge
+// pass, then empties the transaction.
+func (tx *TxObj[PkType, T]) Commit() {
+	if !tx.Pending() {
+		return
+	}
+	out := make(SortedObj[PkType, T], 0, tx.Len())
+	var zero PkType
+	tx.merge(zero, false, func(x T) bool {
+		out = append(out, x)
+		return true
+	})
+	*tx.bag = out
+	tx.Rollback()
+}
+
+// Rollback drops the pending modifications, the array is left unchanged
+func (tx *TxObj[PkType, T]) Rollback() {
+	tx.added = nil
+	clear(tx.removed)
+	tx.removedTotal = 0
+}
+
+// baseCount returns the number of items of the array with the given PRIMARY KEY
+func (tx *TxObj[PkType, T]) baseCount(pk PkType) int {
+	lo, found := slices.BinarySearchFunc(*tx.bag, pk, objComparePK[PkType, T])
+	if !found {
+		return 0
+	}
+	hi, _ := slices.BinarySearchFunc((*tx.bag)[lo:], pk, objUpperBound[PkType, T])
+	return hi
+}
+
+// merge calls the hook on the visible items whose PRIMARY KEY is strictly
+// greater than the marker, or on all the visible items if bounded is false,
+// until the hook returns false. The items of the array come before the
+// pending insertions with the same PRIMARY KEY.
+func (tx *TxObj[PkType, T]) merge(marker PkType, bounded bool, hook func(x T) bool) {
+	base, added := *tx.bag, tx.added
+	if bounded {
+		i, _ := slices.BinarySearchFunc(base, marker, objUpperBound[PkType, T])
+		j, _ := slices.BinarySearchFunc(added, marker, objUpperBound[PkType, T])
+		base, added = base[i:], added[j:]
+	}
+	for len(base) > 0 || len(added) > 0 {
+		if len(base) > 0 && (len(added) == 0 || base[0].PK() <= added[0].PK()) {
+			// Skip the removed items at the start of a run of PRIMARY KEYS
+			pk := base[0].PK()
+			if skip := tx.removed[pk]; skip > 0 {
+				base = base[skip:]
+				if len(base) == 0 || base[0].PK() != pk {
+					continue
+				}
+			}
+			for len(base) > 0 && base[0].PK() == pk {
+				if !hook(base[0]) {
+					return
+				}
+				base = base[1:]
+			}
+			continue
+		}
+		if !hook(added[0]) {
+			return
+		}
+		added = added[1:]
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestTx_Random(T *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	var bag SortedObj[int64, *Obj]
+	for i := 0; i < 200; i++ {
+		bag.Add(&Obj{rng.Int63n(100)})
+	}
+	tx := bag.Begin()
+	for round := 0; round < 20; round++ {
+		before := slices.Clone(bag)
+		model := slices.Clone(bag)
+		for i := 0; i < 100; i++ {
+			v := rng.Int63n(110)
+			switch rng.Intn(3) {
+			case 0:
+				present := model.Has(v)
+				model.Remove(v)
+				if tx.Remove(v) != present {
+					T.Fatal("remove", v)
+				}
+			case 1:
+				x := &Obj{v}
+				model.Add(x)
+				tx.Add(x)
+			default:
+				x := &Obj{v}
+				replaced := model.Has(v)
+				for model.Has(v) {
+					model.Remove(v)
+				}
+				model.Add(x)
+				if tx.Upsert(x) != replaced {
+					T.Fatal("upsert", v)
+				}
+			}
+			if tx.Len() != model.Len() || tx.GetIndex(v) != model.GetIndex(v) {
+				T.Fatal("len", tx.Len(), model.Len(), "index", v)
+			}
+			if x, ok := tx.Get(v); ok != model.Has(v) || (ok && x != model[model.GetIndex(v)]) {
+				T.Fatal("get", v)
+			}
+			if marker := rng.Int63n(110) - 1; !slices.Equal(tx.Slice(marker, 17), model.Slice(marker, 17)) {
+				T.Fatal("slice", marker)
+			}
+		}
+		if !slices.Equal(bag, before) {
+			T.Fatal("array modified before the commit")
+		}
+		if round%2 == 0 {
+			tx.Rollback()
+			if !slices.Equal(bag, before) {
+				T.Fatal("array modified by the rollback")
+			}
+		} else {
+			tx.Commit()
+			if !slices.Equal(bag, model) {
+				T.Fatal("commit")
+			}
+		}
+		if tx.Pending() {
+			T.Fatal("pending")
+		}
+	}
+}
@@ -0,0 +1,534 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SyncPolicy tells when a DurableObj flushes its log to the stable storage
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the log after each operation
+	SyncAlways SyncPolicy = iota
+	// SyncManual only syncs the log on Sync, Snapshot and Close
+	SyncManual
+)
+
+const (
+	walFile      = "wal"
+	snapshotFile = "snapshot"
+	walHeaderSz  = 8
+
+	walOpAdd      = 'a'
+	walOpAppend   = 'A'
+	walOpRemove   = 'r'
+	walOpSnapshot = 's'
+)
+
+// Each record of the log and of the snapshot is framed by its length and its
+// CRC32 (IEEE), both as little-endian uint32. The payload of a log record is
+// the opcode, the sequence number as an uvarint and then
+//   - for an add or a remove, the encoded item;
+//   - for an append, the number of items as an uvarint and the items, each
+//     prefixed with its length as an uvarint.
+//
+// The snapshot starts with a record carrying the walOpSnapshot opcode, the
+// sequence number of the last operation it covers and the number of items,
+// followed by one record per encoded item.
+
+// DurableObj wraps a SortedObj whose modifications are appended to a
+// write-ahead log before they are applied. Snapshot writes the whole array
+// and truncates the log, and OpenDurableObj rebuilds the array from the
+// snapshot and the log after a crash.
+// A failed operation is not applied and leaves no record in the log. If the
+// log cannot be restored after a failed write, all the operations fail with
+// ErrLogBroken and the DurableObj must be opened again.
+// A DurableObj is not safe for concurrent use.
+type DurableObj[PkType Ordered, T WithPK[PkType]] struct {
+	items SortedObj[PkType, T]
+	codec Codec[T]
+	dir   string
+	wal   logFile
+
+	policy  SyncPolicy
+	seq     uint64
+	pending int
+	torn    int64
+	buf     []byte
+	// maintErr holds the error of the last automatic snapshot
+	maintErr error
+	// broken holds the error that left the log in an unknown state
+	broken error
+
+	// SnapshotEvery triggers a snapshot once as many operations have been
+	// logged since the last one. Zero disables the automatic snapshots.
+	// A failed automatic snapshot is reported by MaintenanceErr, not by the
+	// operation that triggered it.
+	SnapshotEvery int
+}
+
+// ErrLogBroken reports the use of a DurableObj whose log could not be restored
+// after a failed write
+var ErrLogBroken = errors.New("log broken")
+
+// logFile is the part of *os.File used by the log
+type logFile interface {
+	io.ReadWriteSeeker
+	io.ReaderAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenDurableObj loads the snapshot and replays the log found in the given
+// directory, that is created if necessary. A torn record at the end of the
+// log, left by a crash during a write, is discarded and the log truncated.
+// A corrupted record anywhere else, including a record whose length runs
+// past the end of the log while valid records follow it, is reported as an
+// ErrChecksum and the log is left untouched.
+func OpenDurableObj[PkType Ordered, T WithPK[PkType]](dir string, codec Codec[T], policy SyncPolicy) (*DurableObj[PkType, T], error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &DurableObj[PkType, T]{codec: codec, dir: dir, policy: policy}
+	if err := d.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	d.wal = wal
+	if err = d.replay(); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Items returns the current array. It must not be modified.
+func (d *DurableObj[PkType, T]) Items() SortedObj[PkType, T] { return d.items }
+
+// Len returns the number of items in the array
+func (d *DurableObj[PkType, T]) Len() int { return d.items.Len() }
+
+// Get returns the item with the given primary key, if present
+func (d *DurableObj[PkType, T]) Get(id PkType) (T, bool) { return d.items.Get(id) }
+
+// Has tests for the presence of an item with the given primary key
+func (d *DurableObj[PkType, T]) Has(id PkType) bool { return d.items.Has(id) }
+
+// Slice returns at most max items whose PK is strictly greater than the marker
+func (d *DurableObj[PkType, T]) Slice(marker PkType, max uint32) []T {
+	return d.items.Slice(marker, max)
+}
+
+// TornBytes returns the size of the torn record discarded at the end of the
+// log by OpenDurableObj, 0 if the log was clean.
+func (d *DurableObj[PkType, T]) TornBytes() int64 { return d.torn }
+
+// MaintenanceErr returns the error of the last automatic snapshot, or nil if
+// it succeeded. The operation that triggered the snapshot is durable in the
+// log anyway, so it doesn't fail, and the snapshot is attempted again by the
+// next operation.
+func (d *DurableObj[PkType, T]) MaintenanceErr() error { return d.maintErr }
+
+// Add logs then inserts the item
+func (d *DurableObj[PkType, T]) Add(a T) error {
+	d.buf = d.codec.AppendItem(d.walOp(walOpAdd), a)
+	if err := d.log(); err != nil {
+		return err
+	}
+	d.items.Add(a)
+	d.afterOp()
+	return nil
+}
+
+// Append logs then inserts the items, as a single record
+func (d *DurableObj[PkType, T]) Append(a ...T) error {
+	if len(a) == 0 {
+		return nil
+	}
+	d.buf = binary.AppendUvarint(d.walOp(walOpAppend), uint64(len(a)))
+	var item []byte
+	for _, x := range a {
+		item = d.codec.AppendItem(item[:0], x)
+		d.buf = binary.AppendUvarint(d.buf, uint64(len(item)))
+		d.buf = append(d.buf, item...)
+	}
+	if err := d.log(); err != nil {
+		return err
+	}
+	d.items.Append(a...)
+	d.afterOp()
+	return nil
+}
+
+// Remove logs then removes the first item with the given primary key.
+// Nothing is logged if no item matches.
+func (d *DurableObj[PkType, T]) Remove(pk PkType) (bool, error) {
+	x, ok := d.items.Get(pk)
+	if !ok {
+		return false, nil
+	}
+	d.buf = d.codec.AppendItem(d.walOp(walOpRemove), x)
+	if err := d.log(); err != nil {
+		return false, err
+	}
+	d.items.Remove(pk)
+	d.afterOp()
+	return true, nil
+}
+
+// Sync flushes the log to the stable storage
+func (d *DurableObj[PkType, T]) Sync() error { return d.wal.Sync() }
+
+// Snapshot atomically replaces the snapshot with the current array, then
+// truncates the log.
+func (d *DurableObj[PkType, T]) Snapshot() error {
+	if d.broken != nil {
+		return d.broken
+	}
+	path := filepath.Join(d.dir, snapshotFile)
+	f, err := os.CreateTemp(d.dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	header := binary.AppendUvarint([]byte{walOpSnapshot}, d.seq)
+	header = binary.AppendUvarint(header, uint64(len(d.items)))
+	w.Write(appendWALRecord(nil, header))
+	var record, item []byte
+	for _, x := range d.items {
+		item = d.codec.AppendItem(item[:0], x)
+		record = appendWALRecord(record[:0], item)
+		w.Write(record)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	if err = syncDir(d.dir); err != nil {
+		return err
+	}
+	// A crash before the truncation is harmless: the records already
+	// covered by the snapshot are skipped by their sequence number.
+	if err = d.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err = d.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d.pending, d.maintErr = 0, nil
+	return d.wal.Sync()
+}
+
+// reset empties the array with a snapshot
+func (d *DurableObj[PkType, T]) reset() error {
+	d.items = nil
+	return d.Snapshot()
+}
+
+// Close syncs and closes the log
+func (d *DurableObj[PkType, T]) Close() error {
+	return errors.Join(d.wal.Sync(), d.wal.Close())
+}
+
+// walOp starts the payload of the next operation into the internal buffer
+func (d *DurableObj[PkType, T]) walOp(op byte) []byte {
+	return binary.AppendUvarint(append(d.buf[:0], op), d.seq+1)
+}
+
+// log appends the payload held by the internal buffer to the log. A failed
+// operation leaves no record in the log, so that its sequence number may be
+// used again: the log is truncated back to its previous end, and the
+// DurableObj is broken if that fails too.
+func (d *DurableObj[PkType, T]) log() error {
+	if d.broken != nil {
+		return d.broken
+	}
+	record := appendWALRecord(make([]byte, 0, walHeaderSz+len(d.buf)), d.buf)
+	end, err := d.wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = d.wal.Write(record); err == nil && d.policy == SyncAlways {
+		err = d.wal.Sync()
+	}
+	if err != nil {
+		if rerr := d.rollback(end); rerr != nil {
+			d.broken = fmt.Errorf("%w: %w", ErrLogBroken, errors.Join(err, rerr))
+		}
+		return err
+	}
+	d.seq++
+	d.pending++
+	return nil
+}
+
+// rollback removes whatever a failed write left past the given end of the log
+func (d *DurableObj[PkType, T]) rollback(end int64) error {
+	if err := d.wal.Truncate(end); err != nil {
+		return err
+	}
+	if _, err := d.wal.Seek(end, io.SeekStart); err != nil {
+		return err
+	}
+	return d.wal.Sync()
+}
+
+// afterOp runs the automatic snapshot, whose error is kept for MaintenanceErr
+func (d *DurableObj[PkType, T]) afterOp() {
+	if d.SnapshotEvery > 0 && d.pending >= d.SnapshotEvery {
+		d.maintErr = d.Snapshot()
+	}
+}
+
+func (d *DurableObj[PkType, T]) loadSnapshot() error {
+	f, err := os.Open(filepath.Join(d.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// The snapshot is renamed once complete, so any damage is a corruption
+	r := &walReader{r: bufio.NewReader(f), remaining: st.Size()}
+	header, err := r.next()
+	if err != nil {
+		return snapshotError(err)
+	}
+	if len(header) == 0 || header[0] != walOpSnapshot {
+		return fmt.Errorf("%w: snapshot header", ErrFormat)
+	}
+	seq, n := binary.Uvarint(header[1:])
+	count, m := binary.Uvarint(header[1+max(n, 0):])
+	if n <= 0 || m <= 0 {
+		return fmt.Errorf("%w: snapshot header", ErrFormat)
+	}
+	items := make(SortedObj[PkType, T], 0, min(count, 1<<16))
+	for i := uint64(0); i < count; i++ {
+		payload, err := r.next()
+		if err != nil {
+			return snapshotError(err)
+		}
+		x, err := d.codec.DecodeItem(payload)
+		if err != nil {
+			return err
+		}
+		items = append(items, x)
+	}
+	// SortedObj tolerates duplicated primary keys, but not disorder
+	for i := 1; i < len(items); i++ {
+		if items[i-1].PK() > items[i].PK() {
+			return &OrderError{Index: i, Err: ErrUnsorted}
+		}
+	}
+	d.items, d.seq = items, seq
+	return nil
+}
+
+func snapshotError(err error) error {
+	if err == io.EOF {
+		return fmt.Errorf("%w: truncated snapshot", ErrFormat)
+	}
+	return err
+}
+
+func (d *DurableObj[PkType, T]) replay() error {
+	st, err := d.wal.Stat()
+	if err != nil {
+		return err
+	}
+	r := &walReader{r: bufio.NewReader(d.wal), remaining: st.Size()}
+	for {
+		payload, err := r.next()
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF || (errors.Is(err, ErrChecksum) && r.remaining == 0) {
+			// The last record was torn by a crash during its write, unless a
+			// valid record follows: then the length of the record is corrupted.
+			tail := make([]byte, st.Size()-r.offset)
+			if _, err = d.wal.ReadAt(tail, r.offset); err != nil {
+				return err
+			}
+			if hasWALRecord(tail[min(walHeaderSz, len(tail)):]) {
+				return fmt.Errorf("wal offset %d: %w: record length", r.offset, ErrChecksum)
+			}
+			d.torn = st.Size() - r.offset
+			if err = d.wal.Truncate(r.offset); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return fmt.Errorf("wal offset %d: %w", r.offset, err)
+		}
+		if err = d.apply(payload); err != nil {
+			return fmt.Errorf("wal offset %d: %w", r.offset, err)
+		}
+		r.commit()
+	}
+	_, err = d.wal.Seek(r.offset, io.SeekStart)
+	return err
+}
+
+// apply replays a log record, unless it is already covered by the snapshot
+func (d *DurableObj[PkType, T]) apply(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("%w: empty record", ErrFormat)
+	}
+	op := payload[0]
+	seq, n := binary.Uvarint(payload[1:])
+	if n <= 0 {
+		return fmt.Errorf("%w: sequence number", ErrFormat)
+	}
+	if seq <= d.seq {
+		return nil
+	}
+	body := payload[1+n:]
+	switch op {
+	case walOpAdd, walOpRemove:
+		x, err := d.codec.DecodeItem(body)
+		if err != nil {
+			return err
+		}
+		if op == walOpAdd {
+			d.items.Add(x)
+		} else {
+			d.items.Remove(x.PK())
+		}
+	case walOpAppend:
+		count, n := binary.Uvarint(body)
+		if n <= 0 {
+			return fmt.Errorf("%w: append count", ErrFormat)
+		}
+		body = body[n:]
+		items := make([]T, 0, min(count, uint64(len(body))))
+		for i := uint64(0); i < count; i++ {
+			size, n := binary.Uvarint(body)
+			if n <= 0 || uint64(len(body)-n) < size {
+				return fmt.Errorf("%w: append item", ErrFormat)
+			}
+			x, err := d.codec.DecodeItem(body[n : n+int(size)])
+			if err != nil {
+				return err
+			}
+			items = append(items, x)
+			body = body[n+int(size):]
+		}
+		d.items.Append(items...)
+	default:
+		return fmt.Errorf("%w: opcode %q", ErrFormat, op)
+	}
+	d.seq = seq
+	d.pending++
+	return nil
+}
+
+func appendWALRecord(buf, payload []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	return append(buf, payload...)
+}
+
+// hasWALRecord tells if a well-formed record starts anywhere in the data
+func hasWALRecord(data []byte) bool {
+	for i := 0; i+walHeaderSz < len(data); i++ {
+		size := binary.LittleEndian.Uint32(data[i:])
+		if size == 0 || uint64(size) > uint64(len(data)-i-walHeaderSz) {
+			continue
+		}
+		payload := data[i+walHeaderSz : i+walHeaderSz+int(size)]
+		if crc32.ChecksumIEEE(payload) == binary.LittleEndian.Uint32(data[i+4:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// walReader reads the framed records of a file of known size
+type walReader struct {
+	r         *bufio.Reader
+	remaining int64
+	offset    int64
+	size      int64
+	buf       []byte
+}
+
+// next returns the payload of the next record, io.EOF at the clean end of the
+// file, io.ErrUnexpectedEOF if the record is truncated or ErrChecksum if its
+// checksum doesn't match.
+func (w *walReader) next() ([]byte, error) {
+	if w.remaining == 0 {
+		return nil, io.EOF
+	}
+	var header [walHeaderSz]byte
+	if w.remaining < walHeaderSz {
+		w.remaining = 0
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, err := io.ReadFull(w.r, header[:]); err != nil {
+		return nil, unexpected(err)
+	}
+	size := int64(binary.LittleEndian.Uint32(header[:]))
+	w.remaining -= walHeaderSz
+	if size > w.remaining {
+		w.remaining = 0
+		return nil, io.ErrUnexpectedEOF
+	}
+	if int64(cap(w.buf)) < size {
+		w.buf = make([]byte, size)
+	}
+	w.buf = w.buf[:size]
+	if _, err := io.ReadFull(w.r, w.buf); err != nil {
+		return nil, unexpected(err)
+	}
+	w.remaining -= size
+	w.size = walHeaderSz + size
+	if crc32.ChecksumIEEE(w.buf) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, ErrChecksum
+	}
+	return w.buf, nil
+}
+
+// commit moves the offset past the last record read
+func (w *walReader) commit() { w.offset += w.size }
+
+// syncDir makes a rename in the directory durable
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2018-2023 Jean-Francois SMIGIELSKI
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package bags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func openDurable(T *testing.T, dir string) *DurableObj[int64, Cmp2Int] {
+	d, err := OpenDurableObj[int64](dir, cmp2IntCodec{}, SyncAlways)
+	if err != nil {
+		T.Fatal(err)
+	}
+	return d
+}
+
+func fillDurable(T *testing.T, d *DurableObj[int64, Cmp2Int]) {
+	for i := 0; i < 10; i++ {
+		if err := d.Add(Cmp2Int{A: 10 - i, B: i}); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if err := d.Append(Cmp2Int{A: 20}, Cmp2Int{A: 15}, Cmp2Int{A: 5, B: 100}); err != nil {
+		T.Fatal(err)
+	}
+	if ok, err := d.Remove(3); err != nil || !ok {
+		T.Fatal(ok, err)
+	}
+	if ok, err := d.Remove(42); err != nil || ok {
+		T.Fatal(ok, err)
+	}
+}
+
+func TestDurable_Replay(T *testing.T) {
+	dir := T.TempDir()
+	d := openDurable(T, dir)
+	fillDurable(T, d)
+	expected := slices.Clone(d.Items())
+	if d.Len() != 12 {
+		T.Fatal(d.Len())
+	}
+	if err := d.Close(); err != nil {
+		T.Fatal(err)
+	}
+
+	d = openDurable(T, dir)
+	defer d.Close()
+	if !slices.Equal(d.Items(), expected) || d.TornBytes() != 0 {
+		T.Fatal(d.Items(), expected)
+	}
+	if x, ok := d.Get(5); !ok || x.B != 5 {
+		T.Fatal(x, ok)
+	}
+}
+
+func TestDurable_Snapshot(T *testing.T) {
+	dir := T.TempDir()
+	d := openDurable(T, dir)
+	fillDurable(T, d)
+	stale, err := os.ReadFile(filepath.Join(dir, walFile))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if err = d.Snapshot(); err != nil {
+		T.Fatal(err)
+	}
+	if st, _ := os.Stat(filepath.Join(dir, walFile)); st.Size() != 0 {
+		T.Fatal(st.Size())
+	}
+	d.Add(Cmp2Int{A: 30})
+	expected := slices.Clone(d.Items())
+	d.Close()
+
+	d = openDurable(T, dir)
+	if !slices.Equal(d.Items(), expected) {
+		T.Fatal(d.Items(), expected)
+	}
+	d.Close()
+
+	// A crash between the snapshot and the truncation of the log leaves
+	// records already covered by the snapshot.
+	if err = os.WriteFile(filepath.Join(dir, walFile), stale, 0o644); err != nil {
+		T.Fatal(err)
+	}
+	d = openDurable(T, dir)
+	defer d.Close()
+	if !slices.Equal(d.Items(), expected[:len(expected)-1]) {
+		T.Fatal(d.Items())
+	}
+}
+
+func TestDurable_AutoSnapshot(T *testing.T) {
+	dir := T.TempDir()
+	d := openDurable(T, dir)
+	d.SnapshotEvery = 5
+	fillDurable(T, d)
+	expected := slices.Clone(d.Items())
+	d.Close()
+	if _, err := os.Stat(filepath.Join(dir, snapshotFile)); err != nil {
+		T.Fatal(err)
+	}
+	d = openDurable(T, dir)
+	defer d.Close()
+	if !slices.Equal(d.Items(), expected) {
+		T.Fatal(d.Items(), expected)
+	}
+}
+
+func TestDurable_SnapshotFailure(T *testing.T) {
+	dir := T.TempDir()
+	d := openDurable(T, dir)
+	d.SnapshotEvery = 3
+
+	// A non-empty directory prevents the snapshot from being renamed
+	blocker := filepath.Join(dir, snapshotFile)
+	if err := os.MkdirAll(filepath.Join(blocker, "x"), 0o755); err != nil {
+		T.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := d.Add(Cmp2Int{A: i}); err != nil {
+			T.Fatal("the write succeeded", err)
+		}
+	}
+	if d.MaintenanceErr() == nil {
+		T.Fatal("snapshot failure not reported")
+	}
+
+	os.RemoveAll(blocker)
+	if err := d.Add(Cmp2Int{A: 5}); err != nil || d.MaintenanceErr() != nil {
+		T.Fatal(err, d.MaintenanceErr())
+	}
+	expected := slices.Clone(d.Items())
+	d.Close()
+	d = openDurable(T, dir)
+	defer d.Close()
+	if !slices.Equal(d.Items(), expected) {
+		T.Fatal(d.Items(), expected)
+	}
+}
+
+func TestDurable_Torn(T *testing.T) {
+	dir := T.TempDir()
+	d := openDurable(T, dir)
+	fillDurable(T, d)
+	d.Add(Cmp2Int{A: 50})
+	d.Close()
+
+	path := filepath.Join(dir, walFile)
+	st, _ := os.Stat(path)
+	if err := os.Truncate(path, st.Size()-3); err != nil {
+		T.Fatal(err)
+	}
+	d = openDurable(T, dir)
+	if d.TornBytes() == 0 || d.Has(50) || d.Len() != 12 {
+		T.Fatal(d.TornBytes(), d.Len())
+	}
+	d.Add(Cmp2Int{A: 60})
+	d.Close()
+
+	d = openDurable(T, dir)
+	if d.TornBytes() != 0 || !d.Has(60) || d.Len() != 13 {
+		T.Fatal(d.TornBytes(), d.Len())
+	}
+	d.Close()
+
+	// A damaged record followed by valid ones is a corruption
+	data, _ := os.ReadFile(path)
+	data[walHeaderSz+1] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	if _, err := OpenDurableObj[int64](dir, cmp2IntCodec{}, SyncManual); !errors.Is(err, ErrChecksum) {
+		T.Fatal(err)
+	}
+}
+
+func TestDurable_CorruptedLength(T *testing.T) {
+	dir := T.TempDir()
+	d := openDurable(T, dir)
+	fillDurable(T, d)
+	d.Close()
+
+	// The length of the third record runs past the end of the log
+	path := filepath.Join(dir, walFile)
+	data, _ := os.ReadFile(path)
+	off := 0
+	for i := 0; i < 2; i++ {
+		off += walHeaderSz + int(binary.LittleEndian.Uint32(data[off:]))
+	}
+	binary.LittleEndian.PutUint32(data[off:], uint32(len(data)))
+	os.WriteFile(path, data, 0o644)
+	if _, err := OpenDurableObj[int64](dir, cmp2IntCodec{}, SyncManual); !errors.Is(err, ErrChecksum) {
+		T.Fatal(err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, data) {
+		T.Fatal("log truncated", len(after), len(data))
+	}
+}
+
+// faultyLog fails the next write, sync or truncation of the log on demand
+type faultyLog struct {
+	*os.File
+	shortWrite, failSync, failTruncate bool
+}
+
+var errFaulty = errors.New("faulty log")
+
+func (f *faultyLog) Write(b []byte) (int, error) {
+	if f.shortWrite {
+		f.shortWrite = false
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errFaulty
+	}
+	return f.File.Write(b)
+}
+
+func (f *faultyLog) Sync() error {
+	if f.failSync {
+		f.failSync = false
+		return errFaulty
+	}
+	return f.File.Sync()
+}
+
+func (f *faultyLog) Truncate(size int64) error {
+	if f.failTruncate {
+		return errFaulty
+	}
+	return f.File.Truncate(size)
+}
+
+func TestDurable_LogFailure(T *testing.T) {
+	for _, fault := range []string{"sync", "short"} {
+		dir := T.TempDir()
+		d := openDurable(T, dir)
+		fillDurable(T, d)
+		log := &faultyLog{File: d.wal.(*os.File)}
+		d.wal = log
+		log.failSync, log.shortWrite = fault == "sync", fault == "short"
+		if err := d.Add(Cmp2Int{A: 50}); !errors.Is(err, errFaulty) || d.Has(50) {
+			T.Fatal(fault, err)
+		}
+		if err := d.Add(Cmp2Int{A: 60}); err != nil {
+			T.Fatal(fault, err)
+		}
+		d.Close()
+
+		d = openDurable(T, dir)
+		if d.TornBytes() != 0 || d.Has(50) || !d.Has(60) || d.Len() != 13 {
+			T.Fatal(fault, d.TornBytes(), d.Len())
+		}
+		d.Close()
+	}
+}
+
+func TestDurable_LogBroken(T *testing.T) {
+	dir := T.TempDir()
+	d := openDurable(T, dir)
+	fillDurable(T, d)
+	log := &faultyLog{File: d.wal.(*os.File), shortWrite: true, failTruncate: true}
+	d.wal = log
+	if err := d.Add(Cmp2Int{A: 50}); !errors.Is(err, errFaulty) {
+		T.Fatal(err)
+	}
+	if err := d.Add(Cmp2Int{A: 60}); !errors.Is(err, ErrLogBroken) || d.Has(60) {
+		T.Fatal(err)
+	}
+	if _, err := d.Remove(1); !errors.Is(err, ErrLogBroken) || !d.Has(1) {
+		T.Fatal(err)
+	}
+	if err := d.Snapshot(); !errors.Is(err, ErrLogBroken) {
+		T.Fatal(err)
+	}
+	d.Close()
+
+	// The torn record is the last one, and is discarded by the next open
+	d = openDurable(T, dir)
+	if d.TornBytes() == 0 || d.Has(50) || d.Len() != 12 {
+		T.Fatal(d.TornBytes(), d.Len())
+	}
+	d.Close()
+}